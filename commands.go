@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/git"
+	"github.com/champ-oss/file-sync/pkg/provider"
+	"github.com/champ-oss/file-sync/pkg/syncer"
+	log "github.com/sirupsen/logrus"
+)
+
+func syncCommand(cfg *config.Config) error {
+	if cfg.DryRun {
+		log.Info("Dry run, no changes will be committed or pushed")
+		return diffCommand(cfg)
+	}
+	p, err := provider.New(cfg)
+	if err != nil {
+		return err
+	}
+	g := git.New(cfg)
+
+	sources, err := syncer.PrepareSources(cfg, p, g)
+	if err != nil {
+		return err
+	}
+	defer syncer.CleanupSources(sources)
+
+	if !cfg.FanOut() {
+		return syncer.Sync(cfg, p, g, sources, cfg.Owner, cfg.Repo, cfg.Workspace).Err
+	}
+
+	targets, err := syncer.Targets(cfg, p)
+	if err != nil {
+		return err
+	}
+	results := syncer.FanOut(cfg, p, g, targets, func(owner, repo, dir string) syncer.Result {
+		return syncer.Sync(cfg, p, g, sources, owner, repo, dir)
+	})
+	syncer.LogSummary(results)
+	return syncer.Err(results)
+}
+
+func diffCommand(cfg *config.Config) error {
+	p, err := provider.New(cfg)
+	if err != nil {
+		return err
+	}
+	g := git.New(cfg)
+	sources, err := syncer.PrepareSources(cfg, p, g)
+	if err != nil {
+		return err
+	}
+	defer syncer.CleanupSources(sources)
+
+	var results []syncer.Result
+	if cfg.FanOut() {
+		targets, err := syncer.Targets(cfg, p)
+		if err != nil {
+			return err
+		}
+		results = syncer.FanOut(cfg, p, g, targets, func(owner, repo, dir string) syncer.Result {
+			return syncer.Diff(g, sources, owner, repo, dir)
+		})
+	} else {
+		results = append(results, syncer.Diff(g, sources, cfg.Owner, cfg.Repo, cfg.Workspace))
+	}
+	syncer.LogSummary(results)
+	return syncer.Err(results)
+}
+
+func validateCommand(cfg *config.Config) error {
+	for _, s := range cfg.GetSources() {
+		log.Infof("source %s: %d files", s.Repo, len(s.Files))
+	}
+	if cfg.FanOut() {
+		log.Infof("targets: %s, org: %s", cfg.Targets, cfg.TargetOrg)
+	} else {
+		log.Infof("target: %s/%s", cfg.Owner, cfg.Repo)
+	}
+	log.Info("configuration is valid")
+	return nil
+}
@@ -0,0 +1,91 @@
+// Package testutil builds the git repos the tests clone from
+package testutil
+
+import (
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Commit writes Files, which maps paths to their content, and commits them. A commit without
+// files is empty.
+type Commit struct {
+	Message string
+	Files   map[string]string
+}
+
+// Repo is a bare repo in a temp dir. Commits are written straight into it with go-git, so no git
+// binary is needed.
+type Repo struct {
+	Dir      string
+	t        *testing.T
+	worktree *git.Worktree
+}
+
+// NewRepo creates a bare repo whose branch has the commits
+func NewRepo(t *testing.T, branch string, commits ...Commit) *Repo {
+	dir := filepath.Join(t.TempDir(), "repo.git")
+	if _, err := git.PlainInit(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	// The bare repo is opened with an in-memory working tree to commit to it
+	storage := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+	repo, err := git.Open(storage, memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch))); err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Repo{Dir: dir, t: t, worktree: worktree}
+	for _, c := range commits {
+		r.Commit(c)
+	}
+	return r
+}
+
+// Commit adds a commit to the branch and returns its SHA
+func (r *Repo) Commit(c Commit) string {
+	var paths []string
+	for p := range c.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := util.WriteFile(r.worktree.Filesystem, p, []byte(c.Files[p]), 0644); err != nil {
+			r.t.Fatal(err)
+		}
+		if _, err := r.worktree.Add(p); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+
+	author := &object.Signature{Name: "testuser", Email: "testuser@example.com", When: time.Now()}
+	hash, err := r.worktree.Commit(c.Message, &git.CommitOptions{Author: author})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return hash.String()
+}
+
+// LicenseRepo creates a bare repo whose master branch has two commits that add and then update LICENSE
+func LicenseRepo(t *testing.T) *Repo {
+	return NewRepo(t, "master",
+		Commit{Message: "license", Files: map[string]string{"LICENSE": "license\n"}},
+		Commit{Message: "updated license", Files: map[string]string{"LICENSE": "updated license\n"}},
+	)
+}
@@ -1,86 +1,174 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/champ-oss/file-sync/pkg/common"
 	"github.com/champ-oss/file-sync/pkg/config"
-	"github.com/champ-oss/file-sync/pkg/git/cli"
-	"github.com/champ-oss/file-sync/pkg/github"
+	"github.com/champ-oss/file-sync/pkg/syncer"
 	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
 )
 
+const defaultCommand = "sync"
+
+const usage = `Usage: file-sync <command> [flags]
+
+Commands:
+  sync      copy files from the source repos, push the changes and open pull requests (default)
+  diff      copy files from the source repos and show which files would change
+  validate  check the configuration
+
+Run "file-sync <command> -h" to list the flags of a command. Every flag can also be set
+with the env variable shown in its description or in the config file.
+`
+
+// Exit codes
+const (
+	exitError         = 1
+	exitUsage         = 2
+	exitCommandFailed = 3
+	exitTargetsFailed = 4
+)
+
+var commands = map[string]func(cfg *config.Config) error{
+	"sync":     syncCommand,
+	"diff":     diffCommand,
+	"validate": validateCommand,
+}
+
 func main() {
 	log.SetLevel(log.DebugLevel)
 
-	workspace := config.GetWorkspace()
-	token := config.GetToken()
-	repoName := config.GetRepoName()
-	ownerName := config.GetOwnerName()
-	sourceRepo := config.GetSourceRepo()
-	files := config.GetFiles()
-	targetBranch := config.GetTargetBranch()
-	pullRequestBranch := config.GetPullRequestBranch()
-	user := config.GetUser()
-	email := config.GetEmail()
-	commitMsg := config.GetCommitMessage()
-
-	sourceDir, err := cli.CloneFromGitHub(sourceRepo, token)
-	if err != nil {
-		log.Fatal(err)
+	if err := run(os.Args[1:]); err != nil {
+		log.Error(common.Redact(err.Error()))
+		os.Exit(exitCode(err))
 	}
+}
 
-	err = cli.SetAuthor(workspace, user, email)
-	if err != nil {
-		panic(err)
-	}
+// usageError is returned when the command line can not be parsed
+type usageError struct {
+	message string
+}
 
-	err = cli.Fetch(workspace)
-	if err != nil {
-		panic(err)
-	}
+func (e *usageError) Error() string {
+	return e.message
+}
 
-	err = cli.Branch(workspace, pullRequestBranch)
-	if err != nil {
-		panic(err)
+// exitCode maps an error returned by run to the exit code of the process
+func exitCode(err error) int {
+	var usageErr *usageError
+	var validationErr *config.ValidationError
+	var commandErr *common.CommandError
+	var fanOutErr *syncer.FanOutError
+	switch {
+	case errors.As(err, &usageErr), errors.As(err, &validationErr):
+		return exitUsage
+	case errors.As(err, &fanOutErr):
+		return exitTargetsFailed
+	case errors.As(err, &commandErr):
+		return exitCommandFailed
+	default:
+		return exitError
 	}
+}
 
-	err = cli.Checkout(workspace, pullRequestBranch)
-	if err != nil {
-		panic(err)
+func run(args []string) error {
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Print(usage)
+		return nil
 	}
 
-	err = cli.Reset(workspace, pullRequestBranch)
-	if err != nil {
-		panic(err)
+	command, ok := commands[name]
+	if !ok {
+		fmt.Print(usage)
+		return &usageError{fmt.Sprintf("unknown command: %s", name)}
 	}
 
-	if err := common.CopySourceFiles(files, sourceDir, workspace); err != nil {
-		log.Fatal(err)
+	cfg, err := loadConfig(name, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+	return command(cfg)
+}
 
-	if modified := cli.AnyModified(workspace, files); !modified {
-		log.Info("all files are up to date")
-	} else {
-		for _, f := range files {
-			err = cli.Add(workspace, f)
-			if err != nil {
-				panic(err)
-			}
+// loadConfig parses the flags of a command and loads the config with the flags that were set
+// taking precedence over env variables and the config file
+func loadConfig(name string, args []string) (*config.Config, error) {
+	flags := flag.NewFlagSet("file-sync "+name, flag.ContinueOnError)
+	envs := map[string]string{}
+	for _, s := range config.Settings {
+		envs[s.Flag] = s.Env
+		description := fmt.Sprintf("%s [$%s]", s.Usage, s.Env)
+		if s.List {
+			flags.Var(&listFlag{}, s.Flag, description)
+		} else if s.Bool {
+			flags.Var(&boolFlag{}, s.Flag, description)
+		} else {
+			flags.String(s.Flag, "", description)
 		}
+	}
 
-		err = cli.Commit(workspace, commitMsg)
-		if err != nil {
-			log.Fatal(err)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
 		}
+		return nil, &usageError{err.Error()}
+	}
+	if flags.NArg() > 0 {
+		return nil, &usageError{fmt.Sprintf("unexpected arguments: %s", strings.Join(flags.Args(), " "))}
+	}
 
-		err = cli.Push(workspace, pullRequestBranch)
-		if err != nil {
-			log.Fatal(err)
-		}
+	overrides := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		overrides[envs[f.Name]] = f.Value.String()
+	})
+	// Outside of GitHub Actions the workspace is the current directory
+	if _, ok := overrides["GITHUB_WORKSPACE"]; !ok && os.Getenv("GITHUB_WORKSPACE") == "" {
+		overrides["GITHUB_WORKSPACE"] = "."
 	}
+	return config.Load(overrides)
+}
 
-	client := github.GetClient(token)
-	err = github.CreatePullRequest(client, ownerName, repoName, "file-sync", pullRequestBranch, targetBranch)
-	if err != nil {
-		log.Fatal(err)
+// listFlag collects the values of a repeated flag as lines
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, "\n")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// boolFlag is a flag that can be given without a value, so --dry-run is the same as --dry-run=true
+type boolFlag struct {
+	value string
+}
+
+func (b *boolFlag) String() string {
+	return b.value
+}
+
+func (b *boolFlag) Set(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return err
 	}
+	b.value = value
+	return nil
+}
+
+func (b *boolFlag) IsBoolFlag() bool {
+	return true
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"github.com/champ-oss/file-sync/internal/testutil"
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/syncer"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setEnv(t *testing.T) {
+	// git is run from the PATH
+	path := os.Getenv("PATH")
+	t.Setenv("PATH", path)
+	os.Clearenv()
+	os.Setenv("PATH", path)
+	t.Setenv("GITHUB_REPOSITORY", "owner1/repo1")
+	t.Setenv("INPUT_REPO", "owner1/source1")
+	t.Setenv("INPUT_FILES", "LICENSE")
+}
+
+func Test_loadConfig_Flags(t *testing.T) {
+	setEnv(t)
+
+	cfg, err := loadConfig("sync", []string{"--repo", "owner1/source2", "--file", "a", "--file", "b:c", "--target-branch", "develop"})
+	assert.NoError(t, err)
+	assert.Equal(t, ".", cfg.Workspace)
+	assert.Equal(t, "owner1/source2", cfg.SourceRepo)
+	assert.Equal(t, "develop", cfg.TargetBranch)
+	assert.Len(t, cfg.Files, 2)
+	assert.Equal(t, "c", cfg.Files[1].Destination)
+}
+
+func Test_loadConfig_Bool_Flags(t *testing.T) {
+	setEnv(t)
+
+	cfg, err := loadConfig("sync", []string{"--dry-run", "--draft", "--delete-missing=false"})
+	assert.NoError(t, err)
+	assert.True(t, cfg.DryRun)
+	assert.True(t, cfg.Draft)
+	assert.False(t, cfg.DeleteMissing)
+
+	_, err = loadConfig("sync", []string{"--dry-run=maybe"})
+	assert.Contains(t, err.Error(), "invalid boolean value")
+}
+
+func Test_loadConfig_Env(t *testing.T) {
+	setEnv(t)
+	t.Setenv("GITHUB_WORKSPACE", "workspace1")
+
+	cfg, err := loadConfig("sync", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "workspace1", cfg.Workspace)
+	assert.Equal(t, "owner1/source1", cfg.SourceRepo)
+}
+
+func Test_loadConfig_Unexpected_Arguments(t *testing.T) {
+	setEnv(t)
+
+	_, err := loadConfig("sync", []string{"foo"})
+	assert.EqualError(t, err, "unexpected arguments: foo")
+}
+
+func Test_run_Validate(t *testing.T) {
+	setEnv(t)
+	assert.NoError(t, run([]string{"validate"}))
+}
+
+func Test_run_Sync_Dry_Run(t *testing.T) {
+	setEnv(t)
+	source := testutil.NewRepo(t, "main", testutil.Commit{Message: "initial commit"})
+	workspaceDir := t.TempDir()
+	_, err := common.RunCommand(workspaceDir, "git", "clone", source.Dir, ".")
+	assert.NoError(t, err)
+	source.Commit(testutil.Commit{Message: "add license", Files: map[string]string{"LICENSE": "license"}})
+
+	// The files are copied, but nothing is committed
+	assert.NoError(t, run([]string{"sync", "--dry-run", "--workspace", workspaceDir, "--repo", source.Dir}))
+	_, err = os.Stat(filepath.Join(workspaceDir, "LICENSE"))
+	assert.NoError(t, err)
+	output, err := common.RunCommand(workspaceDir, "git", "rev-list", "--count", "HEAD")
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n", output)
+}
+
+func Test_run_Invalid(t *testing.T) {
+	setEnv(t)
+	assert.Error(t, run([]string{"validate", "--repository", "foo"}))
+}
+
+func Test_run_Help(t *testing.T) {
+	assert.NoError(t, run([]string{"help"}))
+	assert.NoError(t, run([]string{"validate", "-h"}))
+}
+
+func Test_run_Unknown_Command(t *testing.T) {
+	assert.EqualError(t, run([]string{"foo"}), "unknown command: foo")
+}
+
+func Test_exitCode(t *testing.T) {
+	setEnv(t)
+	assert.Equal(t, exitUsage, exitCode(run([]string{"foo"})))
+	assert.Equal(t, exitUsage, exitCode(run([]string{"validate", "--foo"})))
+	assert.Equal(t, exitUsage, exitCode(run([]string{"validate", "--repository", "foo"})))
+	assert.Equal(t, exitUsage, exitCode(&config.ValidationError{Problems: []string{"test"}}))
+	assert.Equal(t, exitCommandFailed, exitCode(&common.CommandError{Command: "git", ExitCode: 128}))
+	assert.Equal(t, exitTargetsFailed, exitCode(&syncer.FanOutError{Total: 1}))
+	assert.Equal(t, exitError, exitCode(errors.New("test error")))
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 func RemoveDir(dir string) {
@@ -16,10 +17,27 @@ func RemoveDir(dir string) {
 	}
 }
 
-func CopySourceFiles(files []string, sourceDir, destDir string) error {
+// SplitRepo splits a repo path on its last slash, so the owner of a GitLab project in a subgroup,
+// such as group/sub/project, is the full group path
+func SplitRepo(fullName string) (owner, repo string) {
+	i := strings.LastIndex(fullName, "/")
+	if i < 0 {
+		return "", fullName
+	}
+	return fullName[:i], fullName[i+1:]
+}
+
+func CopySourceFiles(files []File, sourceDir, destDir string) error {
 	for _, f := range files {
-		sourcePath := filepath.Join(sourceDir, f)
-		destPath := filepath.Join(destDir, f)
+		sourcePath := filepath.Join(sourceDir, f.Source)
+		destPath := filepath.Join(destDir, f.Destination)
+		if _, err := os.Lstat(sourcePath); os.IsNotExist(err) && f.DeleteMissing {
+			log.Infof("Removing %s which no longer exists in source", destPath)
+			if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
 		log.Debugf("Copying %s to %s", sourcePath, destPath)
 		if err := CopyFile(sourcePath, destPath); err != nil {
 			log.Error("error copying files from source")
@@ -30,32 +48,68 @@ func CopySourceFiles(files []string, sourceDir, destDir string) error {
 }
 
 func CopyFile(source, dest string) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return copySymlink(source, dest)
+	}
+
 	input, err := ioutil.ReadFile(source)
 	if err != nil {
 		return err
 	}
 
+	if err := prepareDestination(dest); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(dest, input, info.Mode().Perm()); err != nil {
+		return err
+	}
+	// WriteFile only applies the mode to new files, so update the mode of existing files as well
+	return os.Chmod(dest, info.Mode().Perm())
+}
+
+func copySymlink(source, dest string) error {
+	target, err := os.Readlink(source)
+	if err != nil {
+		return err
+	}
+
+	if existing, err := os.Readlink(dest); err == nil && existing == target {
+		return nil
+	}
+
+	if err := prepareDestination(dest); err != nil {
+		return err
+	}
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, dest)
+}
+
+// prepareDestination creates the parent directory of dest and removes an existing symlink at dest
+// so that it is replaced rather than written through
+func prepareDestination(dest string) error {
 	if baseDir, _ := filepath.Split(dest); baseDir != "" {
 		if err := os.MkdirAll(baseDir, os.ModePerm); err != nil {
 			return err
 		}
 	}
 
-	err = ioutil.WriteFile(dest, input, 0644)
-	return err
+	if info, err := os.Lstat(dest); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(dest)
+	}
+	return nil
 }
 
+// RunCommand runs a command and prints its output. On failure the stderr output is returned along
+// with a *CommandError.
 func RunCommand(dir, cmd string, args ...string) (output string, err error) {
-	LogCommand(cmd, args...)
-	command := exec.Command(cmd, args...)
-
-	var stdout bytes.Buffer
-	command.Stdout = &stdout
-	var stderr bytes.Buffer
-	command.Stderr = &stderr
-	command.Dir = dir
-
-	err = command.Run()
+	stdout, stderr, err := runCommand(dir, cmd, args...)
 	LogOutput(stdout)
 	LogOutput(stderr)
 
@@ -65,16 +119,36 @@ func RunCommand(dir, cmd string, args ...string) (output string, err error) {
 	return stdout.String(), nil
 }
 
+// RunCommandQuiet is like RunCommand but returns the output without printing it
+func RunCommandQuiet(dir, cmd string, args ...string) (output string, err error) {
+	stdout, stderr, err := runCommand(dir, cmd, args...)
+	if err != nil {
+		return stderr.String(), err
+	}
+	return stdout.String(), nil
+}
+
+// RunCommandNoLog runs a command without logging the command line or its output
 func RunCommandNoLog(dir, cmd string, args ...string) error {
+	_, _, err := execCommand(dir, cmd, args...)
+	return err
+}
+
+func runCommand(dir, cmd string, args ...string) (stdout, stderr bytes.Buffer, err error) {
+	LogCommand(cmd, args...)
+	return execCommand(dir, cmd, args...)
+}
+
+func execCommand(dir, cmd string, args ...string) (stdout, stderr bytes.Buffer, err error) {
 	command := exec.Command(cmd, args...)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
 	command.Dir = dir
 
-	err := command.Run()
-
-	if err != nil {
-		return fmt.Errorf("error running command")
+	if err := command.Run(); err != nil {
+		return stdout, stderr, newCommandError(cmd, args, stdout.String(), stderr.String(), err)
 	}
-	return nil
+	return stdout, stderr, nil
 }
 
 func LogCommand(cmd string, args ...string) {
@@ -82,12 +156,12 @@ func LogCommand(cmd string, args ...string) {
 	for _, a := range args {
 		logMessage += " " + a
 	}
-	log.Info(logMessage)
+	log.Info(Redact(logMessage))
 }
 
 func LogOutput(output bytes.Buffer) {
 	if output.String() == "" {
 		return
 	}
-	fmt.Print(output.String())
+	fmt.Print(Redact(output.String()))
 }
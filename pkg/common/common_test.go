@@ -16,6 +16,17 @@ func Test_RemoveDir(t *testing.T) {
 	})
 }
 
+func Test_SplitRepo(t *testing.T) {
+	for fullName, want := range map[string][2]string{
+		"owner1/repo1":       {"owner1", "repo1"},
+		"group/sub/project1": {"group/sub", "project1"},
+		"repo1":              {"", "repo1"},
+	} {
+		owner, repo := SplitRepo(fullName)
+		assert.Equal(t, want, [2]string{owner, repo}, fullName)
+	}
+}
+
 func Test_copyFile_Success(t *testing.T) {
 	// Write a test file to a test source directory
 	sourceDir, _ := ioutil.TempDir("", "source")
@@ -71,6 +82,75 @@ func Test_copyFile_Create_Dir(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func Test_copyFile_Preserves_Mode(t *testing.T) {
+	// Write an executable test file to a test source directory
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	sourceFile := filepath.Join(sourceDir, "test.sh")
+	err := ioutil.WriteFile(sourceFile, []byte("test"), 0755)
+	assert.NoError(t, err)
+
+	// Write an existing non-executable file to the destination
+	destDir, _ := ioutil.TempDir("", "dest")
+	defer RemoveDir(destDir)
+	destFile := filepath.Join(destDir, "test.sh")
+	err = ioutil.WriteFile(destFile, []byte("old"), 0644)
+	assert.NoError(t, err)
+
+	assert.NoError(t, CopyFile(sourceFile, destFile))
+	info, err := os.Stat(destFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
+
+func Test_copyFile_Symlink(t *testing.T) {
+	// Write a test file and a symlink to it in a test source directory
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	err := ioutil.WriteFile(filepath.Join(sourceDir, "test.txt"), []byte("test"), 0644)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Symlink("test.txt", filepath.Join(sourceDir, "link.txt")))
+
+	// Write an existing regular file where the symlink will be created
+	destDir, _ := ioutil.TempDir("", "dest")
+	defer RemoveDir(destDir)
+	destFile := filepath.Join(destDir, "link.txt")
+	err = ioutil.WriteFile(destFile, []byte("old"), 0644)
+	assert.NoError(t, err)
+
+	assert.NoError(t, CopyFile(filepath.Join(sourceDir, "link.txt"), destFile))
+	target, err := os.Readlink(destFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "test.txt", target)
+}
+
+func Test_copyFile_Replaces_Symlink(t *testing.T) {
+	// Write a test file to a test source directory
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	sourceFile := filepath.Join(sourceDir, "test.txt")
+	err := ioutil.WriteFile(sourceFile, []byte("test"), 0644)
+	assert.NoError(t, err)
+
+	// Create a symlink in the destination that points to another file
+	destDir, _ := ioutil.TempDir("", "dest")
+	defer RemoveDir(destDir)
+	otherFile := filepath.Join(destDir, "other.txt")
+	err = ioutil.WriteFile(otherFile, []byte("other"), 0644)
+	assert.NoError(t, err)
+	destFile := filepath.Join(destDir, "test.txt")
+	assert.NoError(t, os.Symlink("other.txt", destFile))
+
+	// The symlink is replaced and the file it pointed to is left alone
+	assert.NoError(t, CopyFile(sourceFile, destFile))
+	info, err := os.Lstat(destFile)
+	assert.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	content, err := ioutil.ReadFile(otherFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(content))
+}
+
 func Test_copySourceFiles_Success(t *testing.T) {
 	// Write a test file to a test source directory
 	sourceDir, _ := ioutil.TempDir("", "source")
@@ -85,11 +165,51 @@ func Test_copySourceFiles_Success(t *testing.T) {
 	destDir, _ := ioutil.TempDir("", "dest")
 	defer RemoveDir(sourceDir)
 
-	assert.NoError(t, CopySourceFiles([]string{"test.txt"}, sourceDir, destDir))
+	assert.NoError(t, CopySourceFiles([]File{{Source: "test.txt", Destination: "test.txt"}}, sourceDir, destDir))
+}
+
+func Test_copySourceFiles_Mapping(t *testing.T) {
+	// Write a test file to a nested path in a test source directory
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	err := os.MkdirAll(filepath.Join(sourceDir, "templates"), os.ModePerm)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(sourceDir, "templates", "ci.yml"), []byte("test"), 0644)
+	assert.NoError(t, err)
+
+	// Create a test destination directory
+	destDir, _ := ioutil.TempDir("", "dest")
+	defer RemoveDir(destDir)
+
+	files := []File{{Source: "templates/ci.yml", Destination: ".github/workflows/ci.yml"}}
+	assert.NoError(t, CopySourceFiles(files, sourceDir, destDir))
+	content, err := ioutil.ReadFile(filepath.Join(destDir, ".github", "workflows", "ci.yml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "test", string(content))
+}
+
+func Test_copySourceFiles_DeleteMissing(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+
+	// Write a file to the destination that does not exist in the source
+	destDir, _ := ioutil.TempDir("", "dest")
+	defer RemoveDir(destDir)
+	destFile := filepath.Join(destDir, "test.txt")
+	err := ioutil.WriteFile(destFile, []byte("test"), 0644)
+	assert.NoError(t, err)
+
+	files := []File{
+		{Source: "test.txt", Destination: "test.txt", DeleteMissing: true},
+		{Source: "other.txt", Destination: "other.txt", DeleteMissing: true},
+	}
+	assert.NoError(t, CopySourceFiles(files, sourceDir, destDir))
+	_, err = os.Stat(destFile)
+	assert.True(t, os.IsNotExist(err))
 }
 
 func Test_copySourceFiles_Error(t *testing.T) {
-	assert.Error(t, CopySourceFiles([]string{"test.txt"}, "/foo", "/foo"))
+	assert.Error(t, CopySourceFiles([]File{{Source: "test.txt", Destination: "test.txt"}}, "/foo", "/foo"))
 }
 
 func Test_RunCommand_Success(t *testing.T) {
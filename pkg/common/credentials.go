@@ -0,0 +1,13 @@
+package common
+
+// Credentials authenticate git, with basic auth over HTTPS or a private key over SSH. The password
+// is registered as a secret by whatever creates them.
+type Credentials struct {
+	Username string
+	Password string
+	// SSHKeyFile is the path of the private key used for SSH remotes
+	SSHKeyFile string
+	// KnownHostsFile is the path of the known hosts of SSH remotes. Hosts are trusted on first use
+	// when it is empty.
+	KnownHostsFile string
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommandError is returned when an external command such as git fails
+type CommandError struct {
+	Command string
+	Args    []string
+	// ExitCode is -1 when the command could not be started
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Err      error
+}
+
+func newCommandError(cmd string, args []string, stdout, stderr string, err error) *CommandError {
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	return &CommandError{
+		Command:  cmd,
+		Args:     redactAll(args),
+		ExitCode: exitCode,
+		Stdout:   Redact(stdout),
+		Stderr:   Redact(stderr),
+		Err:      err,
+	}
+}
+
+// Error describes the failure by the command name and subcommand only, as the remaining arguments
+// may hold URLs or messages that are too long or sensitive to log
+func (e *CommandError) Error() string {
+	name := e.Command
+	if len(e.Args) > 0 {
+		name += " " + e.Args[0]
+	}
+
+	message := fmt.Sprintf("%s failed", name)
+	if e.ExitCode >= 0 {
+		message += fmt.Sprintf(" with exit code %d", e.ExitCode)
+	} else {
+		message += fmt.Sprintf(": %s", Redact(e.Err.Error()))
+	}
+
+	output := strings.TrimSpace(e.Stderr)
+	if output == "" {
+		output = strings.TrimSpace(e.Stdout)
+	}
+	if output != "" {
+		message += ": " + output
+	}
+	return message
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
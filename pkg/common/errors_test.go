@@ -0,0 +1,34 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_RunCommand_CommandError(t *testing.T) {
+	_, err := RunCommand(t.TempDir(), "git", "status")
+
+	var commandErr *CommandError
+	assert.True(t, errors.As(err, &commandErr))
+	assert.Equal(t, "git", commandErr.Command)
+	assert.Equal(t, []string{"status"}, commandErr.Args)
+	assert.Equal(t, 128, commandErr.ExitCode)
+	assert.Contains(t, commandErr.Stderr, "not a git repository")
+	assert.Contains(t, err.Error(), "git status failed with exit code 128: fatal: not a git repository")
+}
+
+func Test_RunCommand_Not_Found(t *testing.T) {
+	_, err := RunCommand(t.TempDir(), "not-a-command", "foo")
+
+	var commandErr *CommandError
+	assert.True(t, errors.As(err, &commandErr))
+	assert.Equal(t, -1, commandErr.ExitCode)
+	assert.Contains(t, err.Error(), "not-a-command foo failed: exec: ")
+}
+
+func Test_CommandError_Stdout(t *testing.T) {
+	err := &CommandError{Command: "git", Args: []string{"commit", "-m", "test"}, ExitCode: 1, Stdout: "nothing to commit\n", Err: fmt.Errorf("exit status 1")}
+	assert.EqualError(t, err, "git commit failed with exit code 1: nothing to commit")
+}
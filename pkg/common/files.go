@@ -0,0 +1,239 @@
+package common
+
+import (
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const fileMappingSeparator = ":"
+const globMetaChars = "*?["
+
+type File struct {
+	Source        string
+	Destination   string
+	DeleteMissing bool
+	Exclude       []string
+}
+
+func ParseFiles(values []string) []File {
+	var files []File
+	for _, v := range values {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		files = append(files, parseFile(v))
+	}
+	return files
+}
+
+func parseFile(value string) File {
+	parts := strings.SplitN(value, fileMappingSeparator, 2)
+	source := strings.TrimSpace(parts[0])
+	if len(parts) == 1 || strings.TrimSpace(parts[1]) == "" {
+		return File{Source: source, Destination: source}
+	}
+	return File{Source: source, Destination: strings.TrimSpace(parts[1])}
+}
+
+func DestinationPaths(files []File) []string {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Destination)
+	}
+	return paths
+}
+
+// OutsideDir reports whether a path would point outside the directory it is relative to, because it
+// is absolute or starts with ..
+func OutsideDir(p string) bool {
+	clean := path.Clean(filepath.ToSlash(p))
+	return path.IsAbs(clean) || filepath.IsAbs(p) || clean == ".." || strings.HasPrefix(clean, "../")
+}
+
+// SparseCheckoutPatterns returns the sparse checkout patterns that match the source paths of files,
+// or nil when a file is the whole repo
+func SparseCheckoutPatterns(files []File) []string {
+	var patterns []string
+	for _, f := range files {
+		source := path.Clean(filepath.ToSlash(f.Source))
+		if source == "." {
+			return nil
+		}
+		patterns = append(patterns, "/"+source)
+	}
+	return patterns
+}
+
+// ExpandFiles replaces glob patterns and directories with the individual files they match in sourceDir.
+// When a destination is mapped, matched files keep their path relative to the pattern's base directory.
+// Entries with DeleteMissing set also match files in destDir that no longer exist in sourceDir.
+func ExpandFiles(files []File, sourceDir, destDir string) ([]File, error) {
+	var expanded []File
+	seen := map[string]bool{}
+	for _, f := range files {
+		matches, err := expandFile(f, sourceDir, destDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			key := m.Source + fileMappingSeparator + m.Destination
+			if !seen[key] {
+				seen[key] = true
+				expanded = append(expanded, m)
+			}
+		}
+	}
+	log.Debugf("expanded files: %v", expanded)
+	return expanded, nil
+}
+
+func expandFile(f File, sourceDir, destDir string) ([]File, error) {
+	pattern := path.Clean(filepath.ToSlash(f.Source))
+	base := globBase(pattern)
+	if base == pattern {
+		if !isDir(filepath.Join(sourceDir, pattern)) && !(f.DeleteMissing && isDir(filepath.Join(destDir, f.Destination))) {
+			return []File{f}, nil
+		}
+		pattern = path.Join(pattern, "**")
+	}
+
+	matches, err := findFiles(sourceDir, base, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		log.Warningf("no files found matching %s", f.Source)
+	}
+
+	var files []File
+	for _, m := range matches {
+		if isExcluded(f, m) {
+			continue
+		}
+		files = append(files, File{Source: m, Destination: mapPath(m, base, destinationBase(f, base)), DeleteMissing: f.DeleteMissing})
+	}
+	if !f.DeleteMissing {
+		return files, nil
+	}
+
+	// Match the same pattern against the destination to find files that were removed from the source
+	destBase := destinationBase(f, base)
+	destMatches, err := findFiles(destDir, destBase, mapPath(pattern, base, destBase))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range destMatches {
+		source := mapPath(m, destBase, base)
+		if isExcluded(f, source) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(sourceDir, source)); os.IsNotExist(err) {
+			files = append(files, File{Source: source, Destination: m, DeleteMissing: true})
+		}
+	}
+	return files, nil
+}
+
+func isExcluded(f File, source string) bool {
+	for _, e := range f.Exclude {
+		if MatchPattern(path.Clean(filepath.ToSlash(e)), source) {
+			log.Debugf("excluding %s", source)
+			return true
+		}
+	}
+	return false
+}
+
+func destinationBase(f File, base string) string {
+	if f.Destination == f.Source {
+		return base
+	}
+	return path.Clean(filepath.ToSlash(f.Destination))
+}
+
+// mapPath moves a path from one base directory to another
+func mapPath(p, fromBase, toBase string) string {
+	if fromBase != "." {
+		p = strings.TrimPrefix(p, fromBase+"/")
+	}
+	return path.Join(toBase, p)
+}
+
+func isDir(p string) bool {
+	info, err := os.Lstat(p)
+	return err == nil && info.IsDir()
+}
+
+// globBase returns the leading directories of pattern that contain no glob characters
+func globBase(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, s := range segments {
+		if strings.ContainsAny(s, globMetaChars) {
+			if i == 0 {
+				return "."
+			}
+			return path.Join(segments[:i]...)
+		}
+	}
+	return pattern
+}
+
+func findFiles(rootDir, base, pattern string) ([]string, error) {
+	var matches []string
+	baseDir := filepath.Join(rootDir, base)
+	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
+		return matches, nil
+	}
+
+	err := filepath.Walk(baseDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(rootDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if MatchPattern(pattern, rel) {
+			matches = append(matches, rel)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// MatchPattern reports whether a slash separated name matches pattern. In addition to the
+// syntax of path.Match, a "**" segment matches zero or more directories.
+func MatchPattern(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseFiles(t *testing.T) {
+	files := ParseFiles([]string{"LICENSE", " .gitignore ", ""})
+	assert.Equal(t, []File{
+		{Source: "LICENSE", Destination: "LICENSE"},
+		{Source: ".gitignore", Destination: ".gitignore"},
+	}, files)
+}
+
+func Test_ParseFiles_Mapping(t *testing.T) {
+	files := ParseFiles([]string{"templates/ci.yml:.github/workflows/ci.yml", "README.md:"})
+	assert.Equal(t, []File{
+		{Source: "templates/ci.yml", Destination: ".github/workflows/ci.yml"},
+		{Source: "README.md", Destination: "README.md"},
+	}, files)
+}
+
+func Test_DestinationPaths(t *testing.T) {
+	files := []File{
+		{Source: "templates/ci.yml", Destination: ".github/workflows/ci.yml"},
+		{Source: "LICENSE", Destination: "LICENSE"},
+	}
+	assert.Equal(t, []string{".github/workflows/ci.yml", "LICENSE"}, DestinationPaths(files))
+}
+
+func Test_SparseCheckoutPatterns(t *testing.T) {
+	files := []File{
+		{Source: "templates/ci.yml", Destination: ".github/workflows/ci.yml"},
+		{Source: "./modules/*.tf", Destination: "modules"},
+	}
+	assert.Equal(t, []string{"/templates/ci.yml", "/modules/*.tf"}, SparseCheckoutPatterns(files))
+	assert.Nil(t, SparseCheckoutPatterns(append(files, File{Source: ".", Destination: "template"})))
+}
+
+func Test_OutsideDir(t *testing.T) {
+	for _, p := range []string{"..", "../x", "foo/../../x", "/etc/passwd"} {
+		assert.True(t, OutsideDir(p), p)
+	}
+	for _, p := range []string{".", "LICENSE", ".github/workflows/", "foo/../x", "..foo"} {
+		assert.False(t, OutsideDir(p), p)
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, names ...string) {
+	for _, n := range names {
+		p := filepath.Join(dir, filepath.FromSlash(n))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(p, []byte("test"), 0644))
+	}
+}
+
+func Test_ExpandFiles_Glob(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	writeTestFiles(t, sourceDir, ".github/workflows/ci.yml", ".github/workflows/release.yml", ".github/CODEOWNERS")
+
+	files, err := ExpandFiles([]File{{Source: ".github/workflows/*.yml", Destination: ".github/workflows/*.yml"}}, sourceDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []File{
+		{Source: ".github/workflows/ci.yml", Destination: ".github/workflows/ci.yml"},
+		{Source: ".github/workflows/release.yml", Destination: ".github/workflows/release.yml"},
+	}, files)
+}
+
+func Test_ExpandFiles_DoubleStar_Mapping(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	writeTestFiles(t, sourceDir, "templates/a.yml", "templates/nested/b.yml", "templates/nested/c.txt")
+
+	files, err := ExpandFiles([]File{{Source: "templates/**/*.yml", Destination: "config"}}, sourceDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []File{
+		{Source: "templates/a.yml", Destination: "config/a.yml"},
+		{Source: "templates/nested/b.yml", Destination: "config/nested/b.yml"},
+	}, files)
+}
+
+func Test_ExpandFiles_Directory(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	writeTestFiles(t, sourceDir, "scripts/a.sh", "scripts/lib/b.sh", "LICENSE")
+
+	files, err := ExpandFiles([]File{
+		{Source: "scripts/", Destination: "scripts/"},
+		{Source: "LICENSE", Destination: "LICENSE"},
+		{Source: "scripts/a.sh", Destination: "scripts/a.sh"},
+	}, sourceDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []File{
+		{Source: "scripts/a.sh", Destination: "scripts/a.sh"},
+		{Source: "scripts/lib/b.sh", Destination: "scripts/lib/b.sh"},
+		{Source: "LICENSE", Destination: "LICENSE"},
+	}, files)
+}
+
+func Test_ExpandFiles_Exclude(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	writeTestFiles(t, sourceDir, "scripts/a.sh", "scripts/lib/b.sh", "scripts/lib/c.sh")
+
+	files, err := ExpandFiles([]File{
+		{Source: "scripts", Destination: "scripts", Exclude: []string{"scripts/lib/b.sh"}},
+	}, sourceDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"scripts/a.sh", "scripts/lib/c.sh"}, DestinationPaths(files))
+}
+
+func Test_ExpandFiles_Skips_Git_Dir(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	writeTestFiles(t, sourceDir, ".git/config", "README.md")
+
+	files, err := ExpandFiles([]File{{Source: "**", Destination: "**"}}, sourceDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []File{{Source: "README.md", Destination: "README.md"}}, files)
+}
+
+func Test_ExpandFiles_No_Match(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+
+	files, err := ExpandFiles([]File{
+		{Source: "missing/*.yml", Destination: "missing/*.yml"},
+		{Source: "missing.txt", Destination: "missing.txt"},
+	}, sourceDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []File{{Source: "missing.txt", Destination: "missing.txt"}}, files)
+}
+
+func Test_MatchPattern(t *testing.T) {
+	assert.True(t, MatchPattern("*.yml", "ci.yml"))
+	assert.False(t, MatchPattern("*.yml", "workflows/ci.yml"))
+	assert.True(t, MatchPattern("**/*.yml", "ci.yml"))
+	assert.True(t, MatchPattern("**/*.yml", "a/b/ci.yml"))
+	assert.True(t, MatchPattern("a/**", "a/b/c"))
+	assert.False(t, MatchPattern("a/**/c", "b/c"))
+}
+
+func Test_ExpandFiles_DeleteMissing(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	writeTestFiles(t, sourceDir, "templates/a.yml")
+	destDir, _ := ioutil.TempDir("", "dest")
+	defer RemoveDir(destDir)
+	writeTestFiles(t, destDir, "config/a.yml", "config/b.yml", "config/c.txt")
+
+	files, err := ExpandFiles([]File{{Source: "templates/*.yml", Destination: "config", DeleteMissing: true}}, sourceDir, destDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []File{
+		{Source: "templates/a.yml", Destination: "config/a.yml", DeleteMissing: true},
+		{Source: "templates/b.yml", Destination: "config/b.yml", DeleteMissing: true},
+	}, files)
+}
+
+func Test_ExpandFiles_DeleteMissing_Directory(t *testing.T) {
+	sourceDir, _ := ioutil.TempDir("", "source")
+	defer RemoveDir(sourceDir)
+	destDir, _ := ioutil.TempDir("", "dest")
+	defer RemoveDir(destDir)
+	writeTestFiles(t, destDir, "scripts/a.sh")
+
+	files, err := ExpandFiles([]File{{Source: "scripts", Destination: "scripts", DeleteMissing: true}}, sourceDir, destDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []File{{Source: "scripts/a.sh", Destination: "scripts/a.sh", DeleteMissing: true}}, files)
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"strings"
+	"sync"
+)
+
+const redacted = "***"
+
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// AddSecret registers a value that is masked by Redact wherever it appears in logs or errors
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, s := range secrets.values {
+		if s == secret {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, secret)
+}
+
+// Redact masks all registered secrets in text
+func Redact(text string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, s := range secrets.values {
+		text = strings.ReplaceAll(text, s, redacted)
+	}
+	return text
+}
+
+func redactAll(values []string) []string {
+	var masked []string
+	for _, v := range values {
+		masked = append(masked, Redact(v))
+	}
+	return masked
+}
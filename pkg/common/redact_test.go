@@ -0,0 +1,22 @@
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_Redact(t *testing.T) {
+	AddSecret("secret1")
+	AddSecret("secret1")
+	AddSecret("")
+	assert.Equal(t, "https://***@github.com/owner1/repo1", Redact("https://secret1@github.com/owner1/repo1"))
+	assert.Equal(t, "no secrets", Redact("no secrets"))
+}
+
+func Test_CommandError_Redacted(t *testing.T) {
+	AddSecret("secret2")
+	_, err := RunCommand(t.TempDir(), "sh", "-c", "echo secret2 >&2; exit 1")
+	assert.EqualError(t, err, "sh -c failed with exit code 1: ***")
+	assert.Equal(t, "***\n", err.(*CommandError).Stderr)
+	assert.Equal(t, []string{"-c", "echo *** >&2; exit 1"}, err.(*CommandError).Args)
+}
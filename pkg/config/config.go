@@ -1,93 +1,602 @@
 package config
 
 import (
+	"fmt"
+	"github.com/champ-oss/file-sync/pkg/common"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
 )
 
-func GetWorkspace() string {
-	value := getEnvRequired("GITHUB_WORKSPACE")
-	log.Debugf("github workspace: %s", value)
-	return value
+const defaultConfigFile = "file-sync.yml"
+
+const (
+	defaultTargetBranch      = "main"
+	defaultPullRequestBranch = "file-sync"
+	defaultUser              = "file-sync"
+	defaultEmail             = "41898282+github-actions[bot]@users.noreply.github.com"
+	defaultCommitMessage     = "Updated by file-sync"
+	defaultPullRequestTitle  = "file-sync"
+	defaultConcurrency       = 4
+	defaultServerURL         = "https://github.com"
+	defaultAPIURL            = "https://api.github.com"
+	gitLabServerURL          = "https://gitlab.com"
+	bitbucketServerURL       = "https://bitbucket.org"
+	bitbucketAPIURL          = "https://api.bitbucket.org/2.0"
+)
+
+// The git hosting services repos can be synced on
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderBitbucket = "bitbucket"
+)
+
+// The backends that can run git: the git binary, or go-git for containers without one
+const (
+	GitBackendCLI    = "cli"
+	GitBackendNative = "native"
+)
+
+const defaultPullRequestBody = `Files synced from:
+{{ range .Sources }}- {{ . }}
+{{ end }}{{ with .Files }}
+Changed files:
+{{ range . }}- {{ . }}
+{{ end }}{{ end }}`
+
+type Config struct {
+	Workspace          string   `yaml:"-"`
+	Provider           string   `yaml:"provider"`
+	Token              string   `yaml:"-"`
+	AppID              int      `yaml:"app-id"`
+	AppPrivateKey      string   `yaml:"-"`
+	SSHKey             string   `yaml:"-"`
+	SSHKnownHosts      string   `yaml:"ssh-known-hosts"`
+	ServerURL          string   `yaml:"server-url"`
+	APIURL             string   `yaml:"api-url"`
+	GitBackend         string   `yaml:"git-backend"`
+	CacheDir           string   `yaml:"cache-dir"`
+	Owner              string   `yaml:"-"`
+	Repo               string   `yaml:"-"`
+	SourceRepo         string   `yaml:"repo"`
+	SourceRef          string   `yaml:"ref"`
+	Files              []File   `yaml:"files"`
+	Sources            []Source `yaml:"sources"`
+	DeleteMissing      bool     `yaml:"delete-missing"`
+	TargetBranch       string   `yaml:"target-branch"`
+	PullRequestBranch  string   `yaml:"pull-request-branch"`
+	User               string   `yaml:"user"`
+	Email              string   `yaml:"email"`
+	CommitMessage      string   `yaml:"commit-message"`
+	PullRequestTitle   string   `yaml:"pull-request-title"`
+	PullRequestBody    string   `yaml:"pull-request-body"`
+	PullRequestComment string   `yaml:"pull-request-comment"`
+	Labels             []string `yaml:"labels"`
+	Assignees          []string `yaml:"assignees"`
+	Reviewers          []string `yaml:"reviewers"`
+	TeamReviewers      []string `yaml:"team-reviewers"`
+	Draft              bool     `yaml:"draft"`
+	AutoMerge          string   `yaml:"auto-merge"`
+	Targets            []string `yaml:"targets"`
+	TargetOrg          string   `yaml:"target-org"`
+	TargetTopic        string   `yaml:"target-topic"`
+	TargetPattern      string   `yaml:"target-pattern"`
+	Concurrency        int      `yaml:"concurrency"`
+	DryRun             bool     `yaml:"dry-run"`
 }
 
-func GetToken() string {
-	return getEnvOptional("INPUT_TOKEN")
+type Source struct {
+	Repo  string `yaml:"repo"`
+	Ref   string `yaml:"ref"`
+	Files []File `yaml:"files"`
 }
 
-func GetSourceRepo() string {
-	value := getEnvRequired("INPUT_REPO")
-	log.Debugf("source repo: %s", value)
-	return value
+// File is an entry of the files list. It may be written as a "source:destination" string
+// or as a mapping with per-file options.
+type File struct {
+	Source        string   `yaml:"source"`
+	Destination   string   `yaml:"destination"`
+	DeleteMissing *bool    `yaml:"delete-missing"`
+	Exclude       []string `yaml:"exclude"`
 }
 
-func GetOwnerName() string {
-	value := getEnvRequired("GITHUB_REPOSITORY_OWNER")
-	log.Debugf("owner: %s", value)
-	return value
+func (f *File) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		parsed := common.ParseFiles([]string{value.Value})
+		if len(parsed) == 0 {
+			return fmt.Errorf("line %d: file must not be empty", value.Line)
+		}
+		*f = File{Source: parsed[0].Source, Destination: parsed[0].Destination}
+		return nil
+	}
+
+	type plain File
+	return value.Decode((*plain)(f))
 }
 
-func GetTargetBranch() string {
-	value := getEnvRequired("INPUT_TARGET_BRANCH")
-	log.Debugf("target branch: %s", value)
-	return value
+// Setting describes a value that can be set by an env variable or the equivalent command line flag
+type Setting struct {
+	Flag  string
+	Env   string
+	Usage string
+	// List settings hold one value per line
+	List bool
+	// Bool settings are true when their flag is given without a value
+	Bool bool
 }
 
-func GetPullRequestBranch() string {
-	value := getEnvRequired("INPUT_PULL_REQUEST_BRANCH")
-	log.Debugf("pull request branch: %s", value)
-	return value
+var Settings = []Setting{
+	{Flag: "config-file", Env: "INPUT_CONFIG_FILE", Usage: "path to a YAML or JSON config file"},
+	{Flag: "provider", Env: "INPUT_PROVIDER", Usage: "git hosting service: github, gitlab or bitbucket (default: github)"},
+	{Flag: "token", Env: "INPUT_TOKEN", Usage: "GitHub token or PAT, or GitLab or Bitbucket access token"},
+	{Flag: "app-id", Env: "INPUT_APP_ID", Usage: "GitHub App ID to authenticate as instead of a token"},
+	{Flag: "app-private-key", Env: "INPUT_APP_PRIVATE_KEY", Usage: "PEM encoded private key of the GitHub App"},
+	{Flag: "ssh-key", Env: "INPUT_SSH_KEY", Usage: "private key for source repos with an SSH URL, such as a deploy key"},
+	{Flag: "ssh-known-hosts", Env: "INPUT_SSH_KNOWN_HOSTS", Usage: "known hosts of SSH source repos (default: trust on first use)"},
+	{Flag: "server-url", Env: "INPUT_SERVER_URL", Usage: "GitHub server URL (default: $GITHUB_SERVER_URL or https://github.com)"},
+	{Flag: "api-url", Env: "INPUT_API_URL", Usage: "GitHub API URL (default: $GITHUB_API_URL, or derived from the server URL)"},
+	{Flag: "git-backend", Env: "INPUT_GIT_BACKEND", Usage: "how git is run: cli, which needs a git binary, or native (default: cli)"},
+	{Flag: "cache-dir", Env: "INPUT_CACHE_DIR", Usage: "directory to keep source repo clones in between runs (default: clones are removed on exit)"},
+	{Flag: "workspace", Env: "GITHUB_WORKSPACE", Usage: "local clone of the target repo (default: current directory)"},
+	{Flag: "repository", Env: "GITHUB_REPOSITORY", Usage: "target repo in owner/repo format"},
+	{Flag: "owner", Env: "GITHUB_REPOSITORY_OWNER", Usage: "owner of the target repo (default: owner from repository)"},
+	{Flag: "repo", Env: "INPUT_REPO", Usage: "source repo in owner/repo format, or a git URL or local path"},
+	{Flag: "source-ref", Env: "INPUT_SOURCE_REF", Usage: "branch, tag or commit SHA of the source repo"},
+	{Flag: "file", Env: "INPUT_FILES", Usage: "file, directory or glob to sync, optionally as source:destination (repeatable)", List: true},
+	{Flag: "delete-missing", Env: "INPUT_DELETE_MISSING", Usage: "remove files that no longer exist in the source repo", Bool: true},
+	{Flag: "target", Env: "INPUT_TARGETS", Usage: "owner/repo to push files to in fan-out mode (repeatable)", List: true},
+	{Flag: "target-org", Env: "INPUT_TARGET_ORG", Usage: "push files to all repos in this org"},
+	{Flag: "target-topic", Env: "INPUT_TARGET_TOPIC", Usage: "only include target-org repos with this topic"},
+	{Flag: "target-pattern", Env: "INPUT_TARGET_PATTERN", Usage: "only include target-org repos whose name matches this regular expression"},
+	{Flag: "concurrency", Env: "INPUT_CONCURRENCY", Usage: "number of target repos to sync at the same time"},
+	{Flag: "target-branch", Env: "INPUT_TARGET_BRANCH", Usage: "target branch for the pull request"},
+	{Flag: "pull-request-branch", Env: "INPUT_PULL_REQUEST_BRANCH", Usage: "branch to push changes to"},
+	{Flag: "user", Env: "INPUT_USER", Usage: "git username"},
+	{Flag: "email", Env: "INPUT_EMAIL", Usage: "git email"},
+	{Flag: "commit-message", Env: "INPUT_COMMIT_MESSAGE", Usage: "commit message to use when updating files"},
+	{Flag: "pull-request-title", Env: "INPUT_PULL_REQUEST_TITLE", Usage: "pull request title template"},
+	{Flag: "pull-request-body", Env: "INPUT_PULL_REQUEST_BODY", Usage: "pull request body template"},
+	{Flag: "pull-request-comment", Env: "INPUT_PULL_REQUEST_COMMENT", Usage: "template of a comment to post when an open pull request is updated"},
+	{Flag: "label", Env: "INPUT_LABELS", Usage: "label to add to the pull request (repeatable)", List: true},
+	{Flag: "assignee", Env: "INPUT_ASSIGNEES", Usage: "user to assign the pull request to (repeatable)", List: true},
+	{Flag: "reviewer", Env: "INPUT_REVIEWERS", Usage: "user to request a review from (repeatable)", List: true},
+	{Flag: "team-reviewer", Env: "INPUT_TEAM_REVIEWERS", Usage: "team slug to request a review from (repeatable)", List: true},
+	{Flag: "draft", Env: "INPUT_DRAFT", Usage: "open the pull request as a draft", Bool: true},
+	{Flag: "auto-merge", Env: "INPUT_AUTO_MERGE", Usage: "merge the pull request once checks pass using this method: merge, squash or rebase"},
+	{Flag: "dry-run", Env: "INPUT_DRY_RUN", Usage: "show the changes that would be made without committing, pushing or opening pull requests", Bool: true},
 }
 
-func GetUser() string {
-	value := getEnvRequired("INPUT_USER")
-	log.Debugf("user: %s", value)
-	return value
+// ValidationError is returned when the configuration is missing required settings or holds invalid values
+type ValidationError struct {
+	Problems []string
 }
 
-func GetEmail() string {
-	value := getEnvRequired("INPUT_EMAIL")
-	log.Debugf("email: %s", value)
-	return value
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e.Problems, ", "))
 }
 
-func GetCommitMessage() string {
-	value := getEnvRequired("INPUT_COMMIT_MESSAGE")
-	log.Debugf("commit message: %s", value)
-	return value
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Problems: []string{fmt.Sprintf(format, args...)}}
 }
 
-func GetRepoName() string {
-	key := "GITHUB_REPOSITORY"
-	ownerRepo := getEnvRequired(key)
-	parts := strings.Split(ownerRepo, "/")
-	if len(parts) != 2 {
-		log.Fatalf("%s is in unexpected format: %s", key, ownerRepo)
+// env looks up the value of a setting by its env variable name
+type env func(key string) string
+
+// Load reads the config file, then env variables, then overrides keyed by env variable name
+func Load(overrides map[string]string) (*Config, error) {
+	e := env(func(key string) string {
+		if value, ok := overrides[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	})
+
+	cfg := &Config{}
+	cfg.Workspace = e("GITHUB_WORKSPACE")
+
+	if path := getConfigFile(e, cfg.Workspace); path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return nil, err
+		}
 	}
-	log.Debugf("repo name: %s", parts[1])
-	return parts[1]
+
+	if err := cfg.loadEnv(e); err != nil {
+		return nil, err
+	}
+	cfg.setDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	log.Debugf("config: %+v", cfg.redacted())
+	return cfg, nil
+}
+
+func (c *Config) LoadFile(path string) error {
+	log.Infof("Loading config file: %s", path)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// JSON is a subset of YAML so both formats are read by the YAML parser
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return invalid("error parsing config file %s: %s", path, err)
+	}
+	return nil
 }
 
-func GetFiles() []string {
-	value := getEnvRequired("INPUT_FILES")
-	files := strings.Split(value, "\n")
-	log.Debugf("files: %s", files)
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Workspace == "" {
+		problems = append(problems, "workspace is required")
+	}
+	if c.Owner == "" {
+		problems = append(problems, "owner is required")
+	}
+	if c.Repo == "" {
+		problems = append(problems, "repo name is required")
+	}
+	sources := c.GetSources()
+	if len(sources) == 0 {
+		problems = append(problems, "at least one source repo is required")
+	}
+	for i, s := range sources {
+		// In fan-out mode the top level files are synced from the workspace when no source repo is set
+		if s.Repo == "" && !(c.FanOut() && i == 0 && len(c.Files) > 0) {
+			problems = append(problems, "source repo is required")
+		}
+		if len(s.Files) == 0 && s.Repo != "" {
+			problems = append(problems, fmt.Sprintf("%s: at least one file is required", s.Repo))
+		}
+		for i, f := range s.Files {
+			if f.Source == "" {
+				problems = append(problems, fmt.Sprintf("%s: file %d: source is required", s.Repo, i+1))
+			}
+			destination := f.Destination
+			if destination == "" {
+				destination = f.Source
+			}
+			if common.OutsideDir(destination) {
+				problems = append(problems, fmt.Sprintf("%s: file %d: destination %s is outside the repo", s.Repo, i+1, destination))
+			}
+		}
+	}
+
+	for _, t := range c.Targets {
+		if !c.validRepo(t) {
+			problems = append(problems, fmt.Sprintf("target %s must be in owner/repo format", t))
+		}
+	}
+	if (c.TargetTopic != "" || c.TargetPattern != "") && c.TargetOrg == "" {
+		problems = append(problems, "target org is required when filtering targets by topic or pattern")
+	}
+	if c.Concurrency < 1 {
+		problems = append(problems, "concurrency must be at least 1")
+	}
+	if _, err := regexp.Compile(c.TargetPattern); err != nil {
+		problems = append(problems, fmt.Sprintf("target pattern is invalid: %s", err))
+	}
+	switch c.Provider {
+	case ProviderGitHub, ProviderGitLab, ProviderBitbucket:
+	default:
+		problems = append(problems, fmt.Sprintf("provider %s must be github, gitlab or bitbucket", c.Provider))
+	}
+	switch c.GitBackend {
+	case GitBackendCLI, GitBackendNative:
+	default:
+		problems = append(problems, fmt.Sprintf("git backend %s must be cli or native", c.GitBackend))
+	}
+	if c.AppID != 0 && c.Provider != ProviderGitHub {
+		problems = append(problems, "GitHub App authentication is only supported by the github provider")
+	}
+	if c.AppID != 0 && c.AppPrivateKey == "" {
+		problems = append(problems, "app private key is required when authenticating as a GitHub App")
+	}
+	if c.AppID == 0 && c.AppPrivateKey != "" {
+		problems = append(problems, "app id is required when an app private key is set")
+	}
+	for _, u := range []string{c.ServerURL, c.APIURL} {
+		if parsed, err := url.Parse(u); u != "" && (err != nil || parsed.Scheme == "" || parsed.Host == "") {
+			problems = append(problems, fmt.Sprintf("%s is not a valid URL", u))
+		}
+	}
+	switch c.AutoMerge {
+	case "", "merge", "squash", "rebase":
+	default:
+		problems = append(problems, fmt.Sprintf("auto merge method %s must be merge, squash or rebase", c.AutoMerge))
+	}
+	if _, err := template.New("title").Parse(c.PullRequestTitle); err != nil {
+		problems = append(problems, fmt.Sprintf("pull request title is invalid: %s", err))
+	}
+	if _, err := template.New("body").Parse(c.PullRequestBody); err != nil {
+		problems = append(problems, fmt.Sprintf("pull request body is invalid: %s", err))
+	}
+	if _, err := template.New("comment").Parse(c.PullRequestComment); err != nil {
+		problems = append(problems, fmt.Sprintf("pull request comment is invalid: %s", err))
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// FanOut reports whether files are pushed to other target repos instead of the workspace
+func (c *Config) FanOut() bool {
+	return len(c.Targets) > 0 || c.TargetOrg != ""
+}
+
+// GetSources returns the sources list along with the source configured by the top level repo, ref and files
+func (c *Config) GetSources() []Source {
+	var sources []Source
+	if c.SourceRepo != "" || len(c.Files) > 0 {
+		sources = append(sources, Source{Repo: c.SourceRepo, Ref: c.SourceRef, Files: c.Files})
+	}
+	return append(sources, c.Sources...)
+}
+
+// SyncFiles returns the files of a source with per-file options resolved against the global options
+func (c *Config) SyncFiles(source Source) []common.File {
+	var files []common.File
+	for _, f := range source.Files {
+		file := common.File{
+			Source:        f.Source,
+			Destination:   f.Destination,
+			DeleteMissing: c.DeleteMissing,
+			Exclude:       f.Exclude,
+		}
+		if file.Destination == "" {
+			file.Destination = file.Source
+		}
+		if f.DeleteMissing != nil {
+			file.DeleteMissing = *f.DeleteMissing
+		}
+		files = append(files, file)
+	}
 	return files
 }
 
-func getEnvRequired(key string) string {
-	if value := os.Getenv(key); value != "" {
+func (c *Config) loadEnv(e env) error {
+	c.Token = e.get("INPUT_TOKEN", c.Token)
+	common.AddSecret(c.Token)
+	c.AppPrivateKey = e.get("INPUT_APP_PRIVATE_KEY", c.AppPrivateKey)
+	common.AddSecret(c.AppPrivateKey)
+	c.SSHKey = e.get("INPUT_SSH_KEY", c.SSHKey)
+	common.AddSecret(c.SSHKey)
+	c.SSHKnownHosts = e.get("INPUT_SSH_KNOWN_HOSTS", c.SSHKnownHosts)
+	appID, err := e.int("INPUT_APP_ID", c.AppID)
+	if err != nil {
+		return err
+	}
+	c.AppID = appID
+	c.Provider = strings.ToLower(e.get("INPUT_PROVIDER", c.Provider))
+	setDefault(&c.Provider, ProviderGitHub)
+	c.ServerURL = e.get("INPUT_SERVER_URL", c.ServerURL)
+	c.APIURL = e.get("INPUT_API_URL", c.APIURL)
+	switch {
+	case c.ServerURL != "":
+	case c.Provider == ProviderGitHub:
+		// The GITHUB_ variables are set by GitHub Actions for the server the workflow runs on
+		c.ServerURL = e.get("GITHUB_SERVER_URL", defaultServerURL)
+		if c.APIURL == "" {
+			c.APIURL = e("GITHUB_API_URL")
+		}
+	case c.Provider == ProviderGitLab:
+		c.ServerURL = gitLabServerURL
+	case c.Provider == ProviderBitbucket:
+		c.ServerURL = bitbucketServerURL
+	}
+	if c.APIURL == "" {
+		c.APIURL = apiURL(c.Provider, c.ServerURL)
+	}
+	c.GitBackend = strings.ToLower(e.get("INPUT_GIT_BACKEND", c.GitBackend))
+	setDefault(&c.GitBackend, GitBackendCLI)
+	c.CacheDir = e.get("INPUT_CACHE_DIR", c.CacheDir)
+	c.SourceRepo = e.get("INPUT_REPO", c.SourceRepo)
+	c.SourceRef = e.get("INPUT_SOURCE_REF", c.SourceRef)
+	c.TargetBranch = e.get("INPUT_TARGET_BRANCH", c.TargetBranch)
+	c.PullRequestBranch = e.get("INPUT_PULL_REQUEST_BRANCH", c.PullRequestBranch)
+	c.User = e.get("INPUT_USER", c.User)
+	c.Email = e.get("INPUT_EMAIL", c.Email)
+	c.CommitMessage = e.get("INPUT_COMMIT_MESSAGE", c.CommitMessage)
+	c.PullRequestTitle = e.get("INPUT_PULL_REQUEST_TITLE", c.PullRequestTitle)
+	c.PullRequestBody = e.get("INPUT_PULL_REQUEST_BODY", c.PullRequestBody)
+	c.PullRequestComment = e.get("INPUT_PULL_REQUEST_COMMENT", c.PullRequestComment)
+	c.Labels = e.list("INPUT_LABELS", c.Labels)
+	c.Assignees = e.list("INPUT_ASSIGNEES", c.Assignees)
+	c.Reviewers = e.list("INPUT_REVIEWERS", c.Reviewers)
+	c.TeamReviewers = e.list("INPUT_TEAM_REVIEWERS", c.TeamReviewers)
+	c.AutoMerge = e.get("INPUT_AUTO_MERGE", c.AutoMerge)
+
+	if value := e("GITHUB_REPOSITORY"); value != "" {
+		if !c.validRepo(value) {
+			return invalid("GITHUB_REPOSITORY is in unexpected format: %s", value)
+		}
+		c.Owner, c.Repo = common.SplitRepo(value)
+	}
+	c.Owner = e.get("GITHUB_REPOSITORY_OWNER", c.Owner)
+
+	if value := e("INPUT_FILES"); value != "" {
+		c.Files = nil
+		for _, f := range common.ParseFiles(strings.Split(value, "\n")) {
+			c.Files = append(c.Files, File{Source: f.Source, Destination: f.Destination})
+		}
+	}
+
+	c.Targets = e.list("INPUT_TARGETS", c.Targets)
+	c.TargetOrg = e.get("INPUT_TARGET_ORG", c.TargetOrg)
+	c.TargetTopic = e.get("INPUT_TARGET_TOPIC", c.TargetTopic)
+	c.TargetPattern = e.get("INPUT_TARGET_PATTERN", c.TargetPattern)
+
+	deleteMissing, err := e.bool("INPUT_DELETE_MISSING", c.DeleteMissing)
+	if err != nil {
+		return err
+	}
+	c.DeleteMissing = deleteMissing
+
+	draft, err := e.bool("INPUT_DRAFT", c.Draft)
+	if err != nil {
+		return err
+	}
+	c.Draft = draft
+
+	dryRun, err := e.bool("INPUT_DRY_RUN", c.DryRun)
+	if err != nil {
+		return err
+	}
+	c.DryRun = dryRun
+
+	concurrency, err := e.int("INPUT_CONCURRENCY", c.Concurrency)
+	if err != nil {
+		return err
+	}
+	c.Concurrency = concurrency
+	return nil
+}
+
+func (c *Config) setDefaults() {
+	setDefault(&c.TargetBranch, defaultTargetBranch)
+	setDefault(&c.PullRequestBranch, defaultPullRequestBranch)
+	setDefault(&c.User, defaultUser)
+	setDefault(&c.Email, defaultEmail)
+	setDefault(&c.CommitMessage, defaultCommitMessage)
+	setDefault(&c.PullRequestTitle, defaultPullRequestTitle)
+	setDefault(&c.PullRequestBody, defaultPullRequestBody)
+	if c.Concurrency == 0 {
+		c.Concurrency = defaultConcurrency
+	}
+	if c.Token == "" && c.AppID == 0 {
+		log.Warning("no token is configured")
+	}
+}
+
+// validRepo reports whether repo is in owner/repo format. GitLab projects can be in subgroups,
+// such as group/sub/project.
+func (c *Config) validRepo(repo string) bool {
+	segments := strings.Split(repo, "/")
+	valid := len(segments) == 2 || (c.Provider == ProviderGitLab && len(segments) > 2)
+	for _, segment := range segments {
+		valid = valid && segment != ""
+	}
+	return valid
+}
+
+func (c Config) redacted() Config {
+	if c.Token != "" {
+		c.Token = "***"
+	}
+	if c.AppPrivateKey != "" {
+		c.AppPrivateKey = "***"
+	}
+	if c.SSHKey != "" {
+		c.SSHKey = "***"
+	}
+	c.SourceRepo = redactRepo(c.SourceRepo)
+	sources := make([]Source, len(c.Sources))
+	for i, s := range c.Sources {
+		s.Repo = redactRepo(s.Repo)
+		sources[i] = s
+	}
+	c.Sources = sources
+	return c
+}
+
+// redactRepo removes the user info of an HTTP source URL, which may hold a token or password
+func redactRepo(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || u.User == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return repo
+	}
+	u.User = nil
+	return u.String()
+}
+
+// apiURL returns the API URL of a server, which is api.github.com for github.com and /api/v3 on
+// GitHub Enterprise Server. Bitbucket Cloud is the only Bitbucket server supported.
+func apiURL(provider, serverURL string) string {
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	switch {
+	case provider == ProviderGitLab:
+		return serverURL + "/api/v4"
+	case provider == ProviderBitbucket:
+		return bitbucketAPIURL
+	case serverURL == defaultServerURL:
+		return defaultAPIURL
+	}
+	return serverURL + "/api/v3"
+}
+
+// getConfigFile returns the config file from INPUT_CONFIG_FILE, or file-sync.yml in the workspace if it exists
+func getConfigFile(e env, workspace string) string {
+	if value := e("INPUT_CONFIG_FILE"); value != "" {
+		if !filepath.IsAbs(value) && workspace != "" {
+			return filepath.Join(workspace, value)
+		}
 		return value
 	}
-	log.Fatalf("env variable %s is empty", key)
+	path := filepath.Join(workspace, defaultConfigFile)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
 	return ""
 }
 
-func getEnvOptional(key string) string {
-	if value := os.Getenv(key); value != "" {
+func setDefault(value *string, defaultValue string) {
+	if *value == "" {
+		*value = defaultValue
+	}
+}
+
+func (e env) get(key, fallback string) string {
+	if value := e(key); value != "" {
 		return value
 	}
-	log.Warningf("env variable %s is empty", key)
-	return ""
+	return fallback
+}
+
+// list returns the non-empty lines of a setting
+func (e env) list(key string, fallback []string) []string {
+	value := e(key)
+	if value == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(value, "\n") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (e env) bool(key string, fallback bool) (bool, error) {
+	value := e(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, invalid("env variable %s is not a valid boolean: %s", key, value)
+	}
+	return parsed, nil
+}
+
+func (e env) int(key string, fallback int) (int, error) {
+	value := e(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, invalid("env variable %s is not a valid integer: %s", key, value)
+	}
+	return parsed, nil
 }
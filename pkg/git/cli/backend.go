@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"github.com/champ-oss/file-sync/pkg/common"
+)
+
+// Backend runs git operations with the git binary. Credentials are stored in the config of each
+// clone, so later fetches and pushes authenticate the same way as the clone, except for the
+// shallow clones of sources.
+type Backend struct{}
+
+func (Backend) Clone(url string, auth common.Credentials) (string, error) {
+	return CloneWithAuth(url, auth)
+}
+
+func (Backend) FetchShallow(dir, url, ref string, auth common.Credentials, patterns []string) error {
+	return FetchShallow(dir, url, ref, auth, patterns)
+}
+
+func (Backend) Fetch(dir string) error { return Fetch(dir) }
+
+func (Backend) Branch(dir, name string) error { return Branch(dir, name) }
+
+func (Backend) Checkout(dir, rev string) error { return Checkout(dir, rev) }
+
+func (Backend) Reset(dir, branch string) error { return Reset(dir, branch) }
+
+func (Backend) RevParse(dir, rev string) (string, error) { return RevParse(dir, rev) }
+
+func (Backend) Status(dir, file string) (string, error) { return Status(dir, file) }
+
+func (Backend) Add(dir, file string) error { return Add(dir, file) }
+
+func (Backend) Remove(dir, file string) error { return Remove(dir, file) }
+
+func (Backend) Commit(dir, message string) error { return Commit(dir, message) }
+
+func (Backend) Push(dir, branch string) error { return Push(dir, branch) }
+
+func (Backend) SetAuthor(dir, name, email string) error { return SetAuthor(dir, name, email) }
+
+func (Backend) Diff(dir, file string) (string, error) { return Diff(dir, file) }
+
+func (Backend) Differs(dir, rev string, files []string) (bool, error) {
+	return Differs(dir, rev, files)
+}
+
+func (Backend) ChangedFiles(dir, base string) ([]string, error) { return ChangedFiles(dir, base) }
+
+func (Backend) DiffStat(dir, base string) (string, error) { return DiffStat(dir, base) }
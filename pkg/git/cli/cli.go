@@ -1,40 +1,160 @@
 package cli
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/champ-oss/file-sync/pkg/common"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
-func CloneFromGitHub(repo string, token string) (dir string, err error) {
-	log.Infof("Cloning repository: %s", repo)
-	repoWithToken := fmt.Sprintf("https://%s@github.com/%s", token, repo)
-	dir, err = Clone(repoWithToken)
+// CloneWithAuth clones url, authenticating with an http header or SSH command stored in the clone's
+// git config so that the credentials are not part of the remote URL
+func CloneWithAuth(url string, auth common.Credentials) (dir string, err error) {
+	log.Infof("Cloning repository: %s", common.Redact(url))
+	var args []string
+	for _, c := range authConfig(url, auth) {
+		args = append(args, "--config", c)
+	}
+	return clone(url, args...)
+}
+
+// FetchShallow makes dir a clone of url, unless it is one already, and checks out only the commit
+// at ref, or at the default branch when ref is empty, without the blobs of files outside the sparse
+// checkout patterns. All files are checked out when there are no patterns. Refs that cannot be
+// fetched directly, such as abbreviated commit SHAs, are checked out from a full fetch. The
+// credentials are only passed to the git commands, so they are not stored in the clone.
+func FetchShallow(dir, url, ref string, auth common.Credentials, patterns []string) error {
+	log.Infof("Fetching repository %s into %s", common.Redact(url), dir)
+	if err := initRepo(dir, url, patterns); err != nil {
+		return fmt.Errorf("error cloning repo %s: %w", common.Redact(url), err)
+	}
+	var authArgs []string
+	for _, c := range authConfig(url, auth) {
+		authArgs = append(authArgs, "-c", c)
+	}
+	git := func(args ...string) error {
+		return common.RunCommandNoLog(dir, "git", append(authArgs, args...)...)
+	}
+
+	rev := ref
+	if rev == "" {
+		rev = "HEAD"
+	}
+	err := git("fetch", "--quiet", "--depth", "1", "--filter=blob:none", "origin", rev)
+	if err == nil {
+		rev = "FETCH_HEAD"
+	} else if ref != "" {
+		log.Warningf("Could not fetch %s directly, fetching the full history: %s", ref, err)
+		args := []string{"fetch", "--quiet", "--tags", "--filter=blob:none"}
+		if _, statErr := os.Stat(filepath.Join(dir, ".git", "shallow")); statErr == nil {
+			args = append(args, "--unshallow")
+		}
+		err = git(append(args, "origin")...)
+	}
 	if err != nil {
-		return dir, err
+		return fmt.Errorf("error cloning repo %s: %w", common.Redact(url), err)
 	}
-	return dir, nil
+
+	// read-tree applies changed sparse checkout patterns when HEAD was already at rev
+	log.Infof("Checking out %s", rev)
+	if err := git("checkout", "--quiet", "--force", rev); err != nil {
+		return fmt.Errorf("error checking out %s: %w", ref, err)
+	}
+	if err := git("read-tree", "-mu", "HEAD"); err != nil {
+		return fmt.Errorf("error checking out %s: %w", ref, err)
+	}
+	return nil
 }
 
-func Clone(repo string) (dir string, err error) {
+// initRepo creates an empty repo with url as origin, unless dir is a repo already, and sets its
+// sparse checkout patterns
+func initRepo(dir, url string, patterns []string) error {
+	infoDir := filepath.Join(dir, ".git", "info")
+	sparseFile := filepath.Join(infoDir, "sparse-checkout")
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if err := common.RunCommandNoLog(dir, "git", "init", "--quiet"); err != nil {
+			return err
+		}
+		if err := common.RunCommandNoLog(dir, "git", "remote", "add", "origin", url); err != nil {
+			return err
+		}
+	}
+	if len(patterns) == 0 {
+		// A clone that was sparse before has to match every file to check them all out again
+		if _, err := os.Stat(sparseFile); err != nil {
+			return nil
+		}
+		patterns = []string{"/*"}
+	}
+
+	log.Debugf("Sparse checkout patterns: %v", patterns)
+	if err := common.RunCommandNoLog(dir, "git", "config", "core.sparseCheckout", "true"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infoDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sparseFile, []byte(strings.Join(patterns, "\n")+"\n"), 0644)
+}
+
+// authConfig returns the git config, in key=value form, that authenticates with the credentials
+func authConfig(url string, auth common.Credentials) []string {
+	var config []string
+	if auth.Password != "" {
+		config = append(config, AuthHeaderConfig(url, auth))
+	}
+	if auth.SSHKeyFile != "" {
+		config = append(config, SSHCommandConfig(auth))
+	}
+	return config
+}
+
+func clone(repo string, args ...string) (dir string, err error) {
 	log.Debug("Creating temp directory for repository")
 	dir, _ = ioutil.TempDir("", "repo")
 
-	err = common.RunCommandNoLog("./", "git", "clone", repo, dir)
+	args = append([]string{"clone"}, args...)
+	err = common.RunCommandNoLog("./", "git", append(args, repo, dir)...)
 	if err != nil {
-		return dir, fmt.Errorf("error cloning repo %s: %s", repo, err)
+		return dir, fmt.Errorf("error cloning repo %s: %w", common.Redact(repo), err)
 	}
 	return dir, nil
 }
 
-func Fetch(repoDir string) error {
-	output, err := common.RunCommand(repoDir, "git", "fetch")
-	if err != nil {
-		return fmt.Errorf(output)
+// AuthHeaderConfig returns the git config that sends the credentials as basic auth to urls under
+// baseURL, the same way actions/checkout authenticates. The password and header are registered as secrets.
+func AuthHeaderConfig(baseURL string, auth common.Credentials) string {
+	credentials := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+	common.AddSecret(auth.Password)
+	common.AddSecret(credentials)
+	return fmt.Sprintf("http.%s.extraheader=AUTHORIZATION: basic %s", baseURL, credentials)
+}
+
+// SSHCommandConfig returns the git config that connects to SSH remotes with the private key, checking
+// hosts against the known hosts file when there is one
+func SSHCommandConfig(auth common.Credentials) string {
+	command := fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes", shellQuote(auth.SSHKeyFile))
+	if auth.KnownHostsFile != "" {
+		command += fmt.Sprintf(" -o UserKnownHostsFile=%s -o StrictHostKeyChecking=yes", shellQuote(auth.KnownHostsFile))
+	} else {
+		command += " -o StrictHostKeyChecking=accept-new"
 	}
-	return nil
+	return "core.sshCommand=" + command
+}
+
+// shellQuote quotes a value for the shell git runs the SSH command with
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func Fetch(repoDir string) error {
+	_, err := common.RunCommand(repoDir, "git", "fetch")
+	return err
 }
 
 func Branch(repoDir, branchName string) error {
@@ -43,76 +163,117 @@ func Branch(repoDir, branchName string) error {
 		if strings.Contains(output, "already exists") {
 			return nil
 		}
-		return fmt.Errorf(output)
+		return err
 	}
 	return nil
 }
 
 func Checkout(repoDir, branchName string) error {
-	output, err := common.RunCommand(repoDir, "git", "checkout", branchName)
-	if err != nil {
-		return fmt.Errorf(output)
-	}
-	return nil
+	_, err := common.RunCommand(repoDir, "git", "checkout", branchName)
+	return err
 }
 
-func Status(repoDir, fileName string) string {
-	output, err := common.RunCommand(repoDir, "git", "status", "--porcelain", fileName)
+func RevParse(repoDir, rev string) (string, error) {
+	output, err := common.RunCommand(repoDir, "git", "rev-parse", "--verify", rev)
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
-	return output
+	return strings.TrimSpace(output), nil
+}
+
+func Status(repoDir, fileName string) (string, error) {
+	return common.RunCommand(repoDir, "git", "status", "--porcelain", fileName)
 }
 
-func AnyModified(repoDir string, files []string) bool {
+func AnyModified(repoDir string, files []string) (bool, error) {
 	for _, f := range files {
-		if status := Status(repoDir, f); status != "" {
-			return true
+		status, err := Status(repoDir, f)
+		if err != nil {
+			return false, err
+		}
+		if status != "" {
+			return true, nil
 		}
 	}
-	return false
+	return false, nil
 }
 
-func Add(repoDir, fileName string) error {
-	output, err := common.RunCommand(repoDir, "git", "add", fileName)
-	if err != nil {
-		return fmt.Errorf(output)
+// Diff returns the unified diff of a file against HEAD, including untracked and deleted files,
+// without changing the index
+func Diff(repoDir, fileName string) (string, error) {
+	if _, err := common.RunCommandQuiet(repoDir, "git", "ls-files", "--error-unmatch", "--", fileName); err == nil {
+		return common.RunCommandQuiet(repoDir, "git", "diff", "HEAD", "--", fileName)
 	}
-	return nil
+
+	// git diff exits with 1 when an untracked file is compared to /dev/null and differs
+	diff, err := common.RunCommandQuiet(repoDir, "git", "diff", "--no-index", "--", os.DevNull, fileName)
+	var commandErr *common.CommandError
+	if errors.As(err, &commandErr) && commandErr.ExitCode == 1 {
+		return commandErr.Stdout, nil
+	}
+	return diff, err
 }
 
-func Commit(repoDir, message string) error {
-	output, err := common.RunCommand(repoDir, "git", "commit", "-m", message)
-	if err != nil {
-		return fmt.Errorf(output)
+// Differs reports whether any of the files differ between rev and HEAD
+func Differs(repoDir, rev string, files []string) (bool, error) {
+	if len(files) == 0 {
+		return false, nil
 	}
-	return nil
+	args := append([]string{"diff", "--quiet", rev, "HEAD", "--"}, files...)
+	_, err := common.RunCommand(repoDir, "git", args...)
+	var commandErr *common.CommandError
+	if errors.As(err, &commandErr) && commandErr.ExitCode == 1 {
+		return true, nil
+	}
+	return false, err
 }
 
-func Push(repoDir, branchName string) error {
-	output, err := common.RunCommand(repoDir, "git", "push", "--set-upstream", "origin", branchName)
+// ChangedFiles returns the files changed on HEAD since it diverged from base
+func ChangedFiles(repoDir, base string) ([]string, error) {
+	output, err := common.RunCommandQuiet(repoDir, "git", "diff", "--name-only", fmt.Sprintf("%s...HEAD", base))
 	if err != nil {
-		return fmt.Errorf(output)
+		return nil, err
 	}
-	return nil
+	return strings.Fields(output), nil
+}
+
+// DiffStat returns the diffstat of the changes on HEAD since it diverged from base
+func DiffStat(repoDir, base string) (string, error) {
+	return common.RunCommandQuiet(repoDir, "git", "diff", "--stat", fmt.Sprintf("%s...HEAD", base))
+}
+
+func Add(repoDir, fileName string) error {
+	_, err := common.RunCommand(repoDir, "git", "add", fileName)
+	return err
+}
+
+func Remove(repoDir, fileName string) error {
+	_, err := common.RunCommand(repoDir, "git", "rm", "--ignore-unmatch", fileName)
+	return err
+}
+
+func Commit(repoDir, message string) error {
+	_, err := common.RunCommand(repoDir, "git", "commit", "-m", message)
+	return err
+}
+
+func Push(repoDir, branchName string) error {
+	_, err := common.RunCommand(repoDir, "git", "push", "--set-upstream", "origin", branchName)
+	return err
 }
 
 func SetAuthor(repoDir, name, email string) error {
-	output, err := common.RunCommand(repoDir, "git", "config", "user.name", name)
-	if err != nil {
-		return fmt.Errorf(output)
-	}
-	output, err = common.RunCommand(repoDir, "git", "config", "user.email", email)
-	if err != nil {
-		return fmt.Errorf(output)
+	if _, err := common.RunCommand(repoDir, "git", "config", "user.name", name); err != nil {
+		return err
 	}
-	return nil
+	_, err := common.RunCommand(repoDir, "git", "config", "user.email", email)
+	return err
 }
 
 func Reset(repoDir, branchName string) error {
 	output, err := common.RunCommand(repoDir, "git", "reset", "--hard", fmt.Sprintf("origin/%s", branchName))
 	if err != nil && !strings.Contains(output, "unknown revision or path not in the working tree") {
-		return fmt.Errorf(output)
+		return err
 	}
 	return nil
 }
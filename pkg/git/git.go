@@ -0,0 +1,50 @@
+package git
+
+import (
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/git/cli"
+	"github.com/champ-oss/file-sync/pkg/git/native"
+)
+
+// GitBackend runs the git operations of a sync on local clones, which are identified by their directory
+type GitBackend interface {
+	// Clone clones url into a new temp directory, keeping the credentials for later fetches and pushes
+	Clone(url string, auth common.Credentials) (dir string, err error)
+	// FetchShallow makes dir a clone of url, unless it is one already, and checks out only the
+	// commit at ref, a branch, tag or commit SHA, or at the default branch when ref is empty.
+	// Backends that support sparse checkout only check out the files matching the patterns.
+	FetchShallow(dir, url, ref string, auth common.Credentials, patterns []string) error
+	Fetch(dir string) error
+	// Branch creates a branch at HEAD unless it already exists
+	Branch(dir, name string) error
+	// Checkout switches to a branch, creating it from origin when there is no local one, or to a tag or commit
+	Checkout(dir, rev string) error
+	// Reset hard resets the working tree to origin/branch when the branch exists on origin
+	Reset(dir, branch string) error
+	RevParse(dir, rev string) (string, error)
+	// Status returns the porcelain status of a file, which is empty when the file is unchanged
+	Status(dir, file string) (string, error)
+	Add(dir, file string) error
+	// Remove stages the removal of a file, if it is tracked
+	Remove(dir, file string) error
+	Commit(dir, message string) error
+	Push(dir, branch string) error
+	SetAuthor(dir, name, email string) error
+	// Diff returns the unified diff of a file against HEAD, including untracked and deleted files
+	Diff(dir, file string) (string, error)
+	// Differs reports whether any of the files differ between rev and HEAD
+	Differs(dir, rev string, files []string) (bool, error)
+	// ChangedFiles returns the files changed on HEAD since it diverged from base
+	ChangedFiles(dir, base string) ([]string, error)
+	// DiffStat returns the diffstat of the changes on HEAD since it diverged from base
+	DiffStat(dir, base string) (string, error)
+}
+
+// New returns the configured backend: the git binary, or go-git for containers without one
+func New(cfg *config.Config) GitBackend {
+	if cfg.GitBackend == config.GitBackendNative {
+		return native.NewBackend()
+	}
+	return cli.Backend{}
+}
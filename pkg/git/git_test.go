@@ -0,0 +1,14 @@
+package git
+
+import (
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/git/cli"
+	"github.com/champ-oss/file-sync/pkg/git/native"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_New(t *testing.T) {
+	assert.Equal(t, cli.Backend{}, New(&config.Config{GitBackend: config.GitBackendCLI}))
+	assert.IsType(t, &native.Backend{}, New(&config.Config{GitBackend: config.GitBackendNative}))
+}
@@ -0,0 +1,299 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// binaryCheckLength is how much of a file is searched for a NUL byte, the same as git
+const binaryCheckLength = 8000
+
+// Diff returns the unified diff of a file in the working tree against HEAD, including untracked
+// and deleted files
+func (b *Backend) Diff(dir, file string) (string, error) {
+	repo, err := openLocalRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	tree, err := revisionTree(repo, "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	patch := filePatch{}
+	if f, err := tree.File(file); err == nil {
+		content, err := f.Contents()
+		if err != nil {
+			return "", err
+		}
+		patch.from = &diffFile{path: file, mode: f.Mode, hash: f.Hash, content: content}
+	} else if !errors.Is(err, object.ErrFileNotFound) {
+		return "", err
+	}
+	patch.to, err = worktreeFile(dir, file)
+	if err != nil {
+		return "", err
+	}
+
+	if patch.from == nil && patch.to == nil {
+		return "", nil
+	}
+	if patch.from != nil && patch.to != nil && patch.from.hash == patch.to.hash && patch.from.mode == patch.to.mode {
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	if err := fdiff.NewUnifiedEncoder(&buf, fdiff.DefaultContextLines).Encode(patch); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Differs reports whether any of the files differ between rev and HEAD
+func (b *Backend) Differs(dir, rev string, files []string) (bool, error) {
+	if len(files) == 0 {
+		return false, nil
+	}
+	repo, err := openLocalRepo(dir)
+	if err != nil {
+		return false, err
+	}
+	revTree, err := revisionTree(repo, rev)
+	if err != nil {
+		return false, err
+	}
+	headTree, err := revisionTree(repo, "HEAD")
+	if err != nil {
+		return false, err
+	}
+
+	for _, f := range files {
+		if treeEntry(revTree, f) != treeEntry(headTree, f) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ChangedFiles returns the files changed on HEAD since it diverged from base
+func (b *Backend) ChangedFiles(dir, base string) ([]string, error) {
+	patch, err := branchPatch(dir, base)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		if to != nil {
+			files = append(files, to.Path())
+		} else {
+			files = append(files, from.Path())
+		}
+	}
+	return files, nil
+}
+
+// DiffStat returns the diffstat of the changes on HEAD since it diverged from base
+func (b *Backend) DiffStat(dir, base string) (string, error) {
+	patch, err := branchPatch(dir, base)
+	if err != nil {
+		return "", err
+	}
+	stats := patch.Stats()
+	if len(stats) == 0 {
+		return "", nil
+	}
+
+	var additions, deletions int
+	for _, s := range stats {
+		additions += s.Addition
+		deletions += s.Deletion
+	}
+	summary := fmt.Sprintf(" %d %s changed", len(stats), plural(len(stats), "file"))
+	if additions > 0 {
+		summary += fmt.Sprintf(", %d %s(+)", additions, plural(additions, "insertion"))
+	}
+	if deletions > 0 {
+		summary += fmt.Sprintf(", %d %s(-)", deletions, plural(deletions, "deletion"))
+	}
+	return stats.String() + summary + "\n", nil
+}
+
+func plural(count int, word string) string {
+	if count == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// branchPatch returns the patch from the merge base of base and HEAD to HEAD, like git diff base...HEAD
+func branchPatch(dir, base string) (*object.Patch, error) {
+	repo, err := openLocalRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	head, err := revisionCommit(repo, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	baseCommit, err := revisionCommit(repo, base)
+	if err != nil {
+		return nil, err
+	}
+	mergeBases, err := baseCommit.MergeBase(head)
+	if err != nil {
+		return nil, err
+	}
+	if len(mergeBases) == 0 {
+		return nil, fmt.Errorf("%s and HEAD have no common ancestor", base)
+	}
+	return mergeBases[0].Patch(head)
+}
+
+func revisionCommit(repo *git.Repository, rev string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %w", rev, err)
+	}
+	return repo.CommitObject(*hash)
+}
+
+func revisionTree(repo *git.Repository, rev string) (*object.Tree, error) {
+	commit, err := revisionCommit(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// treeEntry returns the entry of a path in tree, or an empty entry when the path does not exist
+func treeEntry(tree *object.Tree, path string) object.TreeEntry {
+	entry, err := tree.FindEntry(path)
+	if err != nil {
+		return object.TreeEntry{}
+	}
+	return *entry
+}
+
+// worktreeFile reads a file in the working tree, returning nil when it does not exist
+func worktreeFile(dir, file string) (*diffFile, error) {
+	path := filepath.Join(dir, file)
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var content []byte
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		content = []byte(target)
+	} else if content, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+	mode, err := filemode.NewFromOSFileMode(info.Mode())
+	if err != nil {
+		return nil, err
+	}
+	return &diffFile{path: file, mode: mode, hash: plumbing.ComputeHash(plumbing.BlobObject, content), content: string(content)}, nil
+}
+
+// filePatch is the change of a single file between HEAD and the working tree, which go-git can
+// only diff as a whole with its status
+type filePatch struct {
+	from, to *diffFile
+}
+
+func (p filePatch) FilePatches() []fdiff.FilePatch {
+	return []fdiff.FilePatch{p}
+}
+
+func (p filePatch) Message() string {
+	return ""
+}
+
+func (p filePatch) IsBinary() bool {
+	return p.from.isBinary() || p.to.isBinary()
+}
+
+// Files returns nil interfaces for a missing side, which the encoder treats as a new or deleted file
+func (p filePatch) Files() (from, to fdiff.File) {
+	if p.from != nil {
+		from = p.from
+	}
+	if p.to != nil {
+		to = p.to
+	}
+	return from, to
+}
+
+func (p filePatch) Chunks() []fdiff.Chunk {
+	if p.IsBinary() {
+		return nil
+	}
+	var chunks []fdiff.Chunk
+	for _, d := range diff.Do(p.from.text(), p.to.text()) {
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			chunks = append(chunks, chunk{content: d.Text, op: fdiff.Equal})
+		case diffmatchpatch.DiffInsert:
+			chunks = append(chunks, chunk{content: d.Text, op: fdiff.Add})
+		case diffmatchpatch.DiffDelete:
+			chunks = append(chunks, chunk{content: d.Text, op: fdiff.Delete})
+		}
+	}
+	return chunks
+}
+
+type diffFile struct {
+	path    string
+	mode    filemode.FileMode
+	hash    plumbing.Hash
+	content string
+}
+
+func (f *diffFile) Hash() plumbing.Hash     { return f.hash }
+func (f *diffFile) Mode() filemode.FileMode { return f.mode }
+func (f *diffFile) Path() string            { return f.path }
+
+func (f *diffFile) text() string {
+	if f == nil {
+		return ""
+	}
+	return f.content
+}
+
+func (f *diffFile) isBinary() bool {
+	if f == nil {
+		return false
+	}
+	content := f.content
+	if len(content) > binaryCheckLength {
+		content = content[:binaryCheckLength]
+	}
+	return bytes.IndexByte([]byte(content), 0) >= 0
+}
+
+type chunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c chunk) Content() string       { return c.content }
+func (c chunk) Type() fdiff.Operation { return c.op }
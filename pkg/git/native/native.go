@@ -1,85 +1,462 @@
 package native
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"github.com/champ-oss/file-sync/pkg/common"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
 	"io/ioutil"
-	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
-func cloneSourceRepo(sourceRepo string) (dir string, err error) {
-	log.Debug("Creating temp directory for source repository")
-	dir, _ = ioutil.TempDir("", "source")
+func init() {
+	// go-git runs git-upload-pack and git-receive-pack for local repos, so they are served in
+	// process instead to work without a git binary
+	client.InstallProtocol("file", server.NewClient(localLoader{}))
+}
+
+// localLoader loads bare repos, and the .git directory of repos with a working tree
+type localLoader struct{}
+
+func (localLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	for _, path := range []string{ep.Path, filepath.Join(ep.Path, git.GitDirName)} {
+		if s, err := server.DefaultLoader.Load(&transport.Endpoint{Path: path}); err == nil {
+			return s, nil
+		}
+	}
+	return nil, transport.ErrRepositoryNotFound
+}
 
-	log.Infof("Cloning source repository %s to %s", sourceRepo, dir)
-	if _, err := git.PlainClone(dir, false, &git.CloneOptions{URL: sourceRepo, Progress: os.Stdout}); err != nil {
-		log.Error("error cloning source repository")
-		return dir, err
+// Backend runs git operations with go-git, so no git binary is needed. The credentials of each
+// clone are kept in memory for later fetches and pushes.
+type Backend struct {
+	mu   sync.Mutex
+	auth map[string]transport.AuthMethod
+}
+
+func NewBackend() *Backend {
+	return &Backend{auth: map[string]transport.AuthMethod{}}
+}
+
+func (b *Backend) Clone(url string, auth common.Credentials) (string, error) {
+	method, err := authMethod(url, auth)
+	if err != nil {
+		return "", err
 	}
+	log.Debug("Creating temp directory for repository")
+	dir, _ := ioutil.TempDir("", "repo")
+	if err := cloneRepo(dir, &git.CloneOptions{URL: url, Auth: method}); err != nil {
+		return dir, fmt.Errorf("error cloning repo %s: %w", common.Redact(url), err)
+	}
+	b.setAuth(dir, method)
 	return dir, nil
 }
 
+// FetchShallow makes dir a clone of only the commit at ref, or at the default branch when ref is
+// empty, and fetches that ref again when dir is already a clone. go-git has no sparse checkout, so
+// all files are checked out. Refs that are not a branch or tag, such as commit SHAs, are checked
+// out from a full clone.
+func (b *Backend) FetchShallow(dir, url, ref string, auth common.Credentials, patterns []string) error {
+	method, err := authMethod(url, auth)
+	if err != nil {
+		return err
+	}
+	if len(patterns) > 0 {
+		log.Debug("Sparse checkout is not supported by the native git backend, checking out all files")
+	}
+
+	// go-git cannot serve shallow clones of local repos, which are fast to clone in full anyway
+	depth := 1
+	if endpoint, err := transport.NewEndpoint(url); err == nil && endpoint.Protocol == "file" {
+		depth = 0
+	}
+
+	repo, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		err = b.cloneShallow(dir, url, ref, method, depth)
+	} else if err == nil {
+		err = updateShallow(repo, dir, ref, method, depth)
+	}
+	if err != nil {
+		return err
+	}
+	b.setAuth(dir, method)
+	return nil
+}
+
+func (b *Backend) cloneShallow(dir, url, ref string, method transport.AuthMethod, depth int) error {
+	references := []plumbing.ReferenceName{plumbing.HEAD}
+	if ref != "" {
+		references = []plumbing.ReferenceName{plumbing.NewBranchReferenceName(ref), plumbing.NewTagReferenceName(ref)}
+	}
+	for _, reference := range references {
+		// A failed clone removes what it wrote to dir, so the next reference can be tried
+		err := cloneRepo(dir, &git.CloneOptions{URL: url, Auth: method, ReferenceName: reference, SingleBranch: true, Depth: depth, Tags: git.NoTags})
+		if err == nil {
+			return nil
+		}
+		if !isReferenceNotFound(err) {
+			return fmt.Errorf("error cloning repo %s: %w", common.Redact(url), err)
+		}
+	}
+
+	log.Infof("%s is not a branch or tag, cloning the full history", ref)
+	if err := cloneRepo(dir, &git.CloneOptions{URL: url, Auth: method}); err != nil {
+		return fmt.Errorf("error cloning repo %s: %w", common.Redact(url), err)
+	}
+	return b.Checkout(dir, ref)
+}
+
+// updateShallow fetches the refs of an existing clone and resets it to the fetched ref
+func updateShallow(repo *git.Repository, dir, ref string, method transport.AuthMethod, depth int) error {
+	log.Infof("Fetching %s", dir)
+	err := repo.Fetch(&git.FetchOptions{Auth: method, Depth: depth, Force: true})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("error fetching: %w", err)
+	}
+
+	// Without a ref the clone is of the default branch, which HEAD is on
+	var revisions []string
+	if ref != "" {
+		revisions = []string{plumbing.NewRemoteReferenceName(git.DefaultRemoteName, ref).String(), plumbing.NewTagReferenceName(ref).String(), ref}
+	} else if head, err := repo.Head(); err != nil {
+		return err
+	} else if head.Name().IsBranch() {
+		revisions = []string{plumbing.NewRemoteReferenceName(git.DefaultRemoteName, head.Name().Short()).String()}
+	} else {
+		revisions = []string{head.Hash().String()}
+	}
+	for _, rev := range revisions {
+		if hash, err := repo.ResolveRevision(plumbing.Revision(rev)); err == nil {
+			_, worktree, err := openWorktree(dir)
+			if err != nil {
+				return err
+			}
+			log.Infof("Resetting to %s", hash)
+			return worktree.Reset(&git.ResetOptions{Commit: *hash, Mode: git.HardReset})
+		}
+	}
+	return fmt.Errorf("error checking out %s: %w", ref, plumbing.ErrReferenceNotFound)
+}
+
+func (b *Backend) Fetch(dir string) error {
+	repo, err := openLocalRepo(dir)
+	if err != nil {
+		return err
+	}
+	log.Infof("Fetching %s", dir)
+	err = repo.Fetch(&git.FetchOptions{Auth: b.authFor(dir, repo)})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("error fetching: %w", err)
+	}
+	return nil
+}
+
+func (b *Backend) Branch(dir, name string) error {
+	repo, err := openLocalRepo(dir)
+	if err != nil {
+		return err
+	}
+	ref := plumbing.NewBranchReferenceName(name)
+	if _, err := repo.Reference(ref, false); err == nil {
+		return nil
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	log.Infof("Creating branch: %s", name)
+	return repo.Storer.SetReference(plumbing.NewHashReference(ref, head.Hash()))
+}
+
+func (b *Backend) Checkout(dir, rev string) error {
+	repo, worktree, err := openWorktree(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := repo.Reference(plumbing.NewBranchReferenceName(rev), false); err == nil {
+		return checkOutBranch(worktree, rev, plumbing.ZeroHash)
+	}
+	if remote, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, rev), true); err == nil {
+		return checkOutBranch(worktree, rev, remote.Hash())
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return fmt.Errorf("error checking out %s: %w", rev, err)
+	}
+	log.Infof("Checking out commit: %s", hash)
+	return worktree.Checkout(&git.CheckoutOptions{Hash: *hash})
+}
+
+func (b *Backend) Reset(dir, branch string) error {
+	repo, worktree, err := openWorktree(dir)
+	if err != nil {
+		return err
+	}
+	remote, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("Resetting to origin/%s", branch)
+	return worktree.Reset(&git.ResetOptions{Commit: remote.Hash(), Mode: git.HardReset})
+}
+
+func (b *Backend) RevParse(dir, rev string) (string, error) {
+	repo, err := openLocalRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %w", rev, err)
+	}
+	return hash.String(), nil
+}
+
+// Status returns the status of a file in the porcelain format of git status
+func (b *Backend) Status(dir, file string) (string, error) {
+	_, worktree, err := openWorktree(dir)
+	if err != nil {
+		return "", err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return "", fmt.Errorf("error reading the status of %s: %w", dir, err)
+	}
+
+	var paths []string
+	for path, s := range status {
+		if s.Staging == git.Unmodified && s.Worktree == git.Unmodified {
+			continue
+		}
+		if path == file || strings.HasPrefix(path, strings.TrimSuffix(file, "/")+"/") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var output string
+	for _, path := range paths {
+		output += fmt.Sprintf("%c%c %s\n", status[path].Staging, status[path].Worktree, path)
+	}
+	return output, nil
+}
+
+func (b *Backend) Add(dir, file string) error {
+	_, worktree, err := openWorktree(dir)
+	if err != nil {
+		return err
+	}
+	return gitAddFiles([]string{file}, worktree)
+}
+
+func (b *Backend) Remove(dir, file string) error {
+	_, worktree, err := openWorktree(dir)
+	if err != nil {
+		return err
+	}
+	log.Debugf("git rm %s", file)
+	if _, err := worktree.Remove(file); err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+		return fmt.Errorf("error removing %s: %w", file, err)
+	}
+	return nil
+}
+
+// Commit commits the index as the author set in the repo config
+func (b *Backend) Commit(dir, message string) error {
+	repo, worktree, err := openWorktree(dir)
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	_, err = createCommit(worktree, message, cfg.User.Name, cfg.User.Email)
+	return err
+}
+
+func (b *Backend) Push(dir, branch string) error {
+	repo, err := openLocalRepo(dir)
+	if err != nil {
+		return err
+	}
+	return gitPush(repo, branch, b.authFor(dir, repo))
+}
+
+func (b *Backend) SetAuthor(dir, name, email string) error {
+	repo, err := openLocalRepo(dir)
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg.User.Name = name
+	cfg.User.Email = email
+	return repo.SetConfig(cfg)
+}
+
+func (b *Backend) setAuth(dir string, method transport.AuthMethod) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.auth[dir] = method
+}
+
+// authFor returns the credentials the repo in dir was cloned with. Repos cloned by something else,
+// such as actions/checkout, are authenticated with the http extraheader in their config.
+func (b *Backend) authFor(dir string, repo *git.Repository) transport.AuthMethod {
+	b.mu.Lock()
+	method, ok := b.auth[dir]
+	b.mu.Unlock()
+	if ok {
+		return method
+	}
+	return extraHeaderAuth(repo)
+}
+
+// authMethod returns the go-git equivalent of the credentials, using the SSH key for SSH remotes
+func authMethod(url string, auth common.Credentials) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo URL %s: %w", common.Redact(url), err)
+	}
+
+	if endpoint.Protocol == "ssh" && auth.SSHKeyFile != "" {
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+		keys, err := gitssh.NewPublicKeysFromFile(user, auth.SSHKeyFile, "")
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSH key: %w", err)
+		}
+		// Without known hosts any host key is accepted, like the trust on first use of the cli backend
+		keys.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		if auth.KnownHostsFile != "" {
+			keys.HostKeyCallback, err = gitssh.NewKnownHostsCallback(auth.KnownHostsFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading SSH known hosts: %w", err)
+			}
+		}
+		return keys, nil
+	}
+	if auth.Password != "" {
+		return &http.BasicAuth{Username: auth.Username, Password: auth.Password}, nil
+	}
+	return nil, nil
+}
+
+// extraHeaderAuth reads the basic auth header that actions/checkout and the cli backend store in
+// the http.<url>.extraheader config of the origin URL
+func extraHeaderAuth(repo *git.Repository) transport.AuthMethod {
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil
+	}
+	remote, ok := cfg.Remotes[git.DefaultRemoteName]
+	if !ok || len(remote.URLs) == 0 {
+		return nil
+	}
+	for _, s := range cfg.Raw.Section("http").Subsections {
+		if !strings.HasPrefix(remote.URLs[0], s.Name) {
+			continue
+		}
+		header := s.Options.Get("extraheader")
+		if !strings.HasPrefix(strings.ToLower(header), "authorization: basic ") {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[len("authorization: basic "):]))
+		if err != nil {
+			continue
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) == 2 {
+			return &http.BasicAuth{Username: parts[0], Password: parts[1]}
+		}
+	}
+	return nil
+}
+
+func cloneRepo(dir string, opts *git.CloneOptions) error {
+	log.Infof("Cloning repository %s to %s", common.Redact(opts.URL), dir)
+	_, err := git.PlainClone(dir, false, opts)
+	return err
+}
+
+// isReferenceNotFound reports whether a clone failed because the remote has no such branch or tag
+func isReferenceNotFound(err error) bool {
+	var noMatch git.NoMatchingRefSpecError
+	return errors.Is(err, plumbing.ErrReferenceNotFound) || errors.As(err, &noMatch)
+}
+
 func openLocalRepo(path string) (repo *git.Repository, err error) {
-	log.Infof("Opening local repository: %s", path)
+	log.Debugf("Opening local repository: %s", path)
 	repo, err = git.PlainOpen(path)
 	if err != nil {
-		log.Error("error opening local directory as git repository")
-		return nil, err
+		return nil, fmt.Errorf("error opening %s as a git repository: %w", path, err)
 	}
 	return repo, nil
 }
 
-func isWorktreeModified(worktree *git.Worktree) (bool, error) {
-	status, err := worktree.Status()
+func openWorktree(path string) (*git.Repository, *git.Worktree, error) {
+	repo, err := openLocalRepo(path)
 	if err != nil {
-		log.Error("error running git status")
-		return false, err
+		return nil, nil, err
 	}
-	log.Info("git status")
-	fmt.Print(status)
-	return !status.IsClean(), nil
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, worktree, nil
 }
 
-func checkOutBranch(worktree *git.Worktree, branchName string) error {
+// checkOutBranch switches to a local branch, creating it at start when it does not exist yet
+func checkOutBranch(worktree *git.Worktree, branchName string, start plumbing.Hash) error {
 	log.Infof("Checking out branch: %s", branchName)
 	err := worktree.Checkout(&git.CheckoutOptions{
 		Branch: plumbing.NewBranchReferenceName(branchName),
+		Hash:   start,
 		Create: true,
-		Keep:   true,
 	})
 
 	if err != nil && strings.Contains(err.Error(), "already exists") {
-
 		log.Debugf("Branch already exists. Switching to existing branch: %s", branchName)
-		if err := worktree.Checkout(&git.CheckoutOptions{
-			Branch: plumbing.NewBranchReferenceName(branchName),
-			Create: false,
-			Keep:   true,
-		}); err != nil {
-			return err
-		} else {
-			return nil
-		}
+		err = worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branchName)})
 	}
-
 	if err != nil {
-		log.Errorf("Error checking out branch: %s", branchName)
-		return err
+		return fmt.Errorf("error checking out branch %s: %w", branchName, err)
 	}
 	return nil
 }
+
 func gitAddFiles(files []string, worktree *git.Worktree) error {
 	for _, f := range files {
 		log.Debugf("git add %s", f)
 		if _, err := worktree.Add(f); err != nil {
-			log.Errorf("error running git add %s", f)
-			return err
+			return fmt.Errorf("error adding %s: %w", f, err)
 		}
 	}
 	return nil
@@ -95,25 +472,22 @@ func createCommit(worktree *git.Worktree, msg, name, email string) (plumbing.Has
 		},
 	})
 	if err != nil {
-		log.Error("error creating commit")
-		return hash, err
+		return hash, fmt.Errorf("error creating commit: %w", err)
 	}
 	log.Infof("Created commit: %s", hash)
 	return hash, nil
 }
 
-func gitPush(repo *git.Repository, username, password string) error {
-	log.Info("Running git push")
+func gitPush(repo *git.Repository, branchName string, auth transport.AuthMethod) error {
+	log.Infof("Pushing branch: %s", branchName)
+	ref := plumbing.NewBranchReferenceName(branchName)
 	err := repo.Push(&git.PushOptions{
-		Progress: os.Stdout,
-		Auth: &http.BasicAuth{
-			Username: username,
-			Password: password,
-		},
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", ref, ref))},
+		Auth:       auth,
 	})
-	if err != nil {
-		log.Error("error running git push")
-		return err
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("error pushing branch %s: %w", branchName, err)
 	}
 	log.Infof("Successfully pushed")
 	return nil
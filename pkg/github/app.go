@@ -0,0 +1,196 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/google/go-github/v44/github"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLifetime is below the ten minute maximum accepted by GitHub to allow for clock drift
+	jwtLifetime = 9 * time.Minute
+	jwtBackdate = time.Minute
+	// tokenRefreshWindow is how long before expiry an installation token is replaced
+	tokenRefreshWindow = 5 * time.Minute
+)
+
+var now = time.Now
+
+// TokenProvider returns the token used to access a repo, or an org when repo is empty
+type TokenProvider interface {
+	Token(owner, repo string) (string, error)
+}
+
+// StaticToken is a personal access token or GITHUB_TOKEN used for every repo
+type StaticToken string
+
+func (t StaticToken) Token(_, _ string) (string, error) {
+	return string(t), nil
+}
+
+// NewClient returns a client for the API at baseURL authenticated with the token for owner/repo
+func NewClient(tokens TokenProvider, baseURL, owner, repo string) (*github.Client, error) {
+	token, err := tokens.Token(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	return GetClient(token, baseURL)
+}
+
+// App authenticates as a GitHub App installation. It signs a JWT with the app's private key and
+// exchanges it for an installation token of the installation that has access to each repo.
+type App struct {
+	id     int64
+	key    *rsa.PrivateKey
+	client *github.Client
+
+	mu sync.Mutex
+	// installations maps an owner/repo, or an owner for org installations, to its installation id
+	installations map[string]int64
+	tokens        map[int64]*github.InstallationToken
+}
+
+func NewApp(id int64, privateKey []byte, baseURL string) (*App, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	app := &App{id: id, key: key, installations: map[string]int64{}, tokens: map[int64]*github.InstallationToken{}}
+
+	app.client, err = newClient(&http.Client{Transport: &jwtTransport{app: app}}, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// Token returns an installation token for owner/repo, creating a new one when the cached token
+// is about to expire
+func (a *App) Token(owner, repo string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id, err := a.installation(owner, repo)
+	if err != nil {
+		return "", err
+	}
+	if token, ok := a.tokens[id]; ok && now().Add(tokenRefreshWindow).Before(token.GetExpiresAt()) {
+		return token.GetToken(), nil
+	}
+
+	log.Infof("creating token for GitHub App installation %d", id)
+	var token *github.InstallationToken
+	err = withRetry(func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		token, resp, err = a.client.Apps.CreateInstallationToken(context.Background(), id, nil)
+		return resp, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating token for GitHub App installation %d: %w", id, err)
+	}
+	common.AddSecret(token.GetToken())
+	a.tokens[id] = token
+	return token.GetToken(), nil
+}
+
+func (a *App) installation(owner, repo string) (int64, error) {
+	key := owner
+	if repo != "" {
+		key = fmt.Sprintf("%s/%s", owner, repo)
+	}
+	if id, ok := a.installations[key]; ok {
+		return id, nil
+	}
+
+	var installation *github.Installation
+	err := withRetry(func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		if repo == "" {
+			installation, resp, err = a.client.Apps.FindOrganizationInstallation(context.Background(), owner)
+		} else {
+			installation, resp, err = a.client.Apps.FindRepositoryInstallation(context.Background(), owner, repo)
+		}
+		return resp, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error finding GitHub App installation for %s: %w", key, err)
+	}
+	a.installations[key] = installation.GetID()
+	return installation.GetID(), nil
+}
+
+// jwt returns a JSON web token signed with the app's private key
+func (a *App) jwt() (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	issued := now().Add(-jwtBackdate)
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": issued.Unix(),
+		"exp": issued.Add(jwtLifetime).Unix(),
+		"iss": strconv.FormatInt(a.id, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwtTransport authenticates the requests of the app itself, as opposed to its installations
+type jwtTransport struct {
+	app *App
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.app.jwt()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// parsePrivateKey reads a PEM encoded RSA key in the PKCS#1 format GitHub generates, or PKCS#8
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing GitHub App private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key is not an RSA key")
+	}
+	return key, nil
+}
@@ -0,0 +1,109 @@
+package github
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestApp(t *testing.T) (*fakeGitHub, *App) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	fake, _ := newFakeGitHub(t)
+	fake.appKey = &key.PublicKey
+
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	app, err := NewApp(123, privateKey, fake.server.URL)
+	assert.NoError(t, err)
+	return fake, app
+}
+
+func Test_App_Token(t *testing.T) {
+	fake, app := newTestApp(t)
+
+	token, err := app.Token("owner1", "repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghs_1", token)
+
+	client, err := NewClient(app, fake.server.URL, "owner1", "repo1")
+	assert.NoError(t, err)
+	assert.NoError(t, CreatePullRequest(client, "owner1", "repo1", PullRequest{Title: "title", Head: "file-sync", Base: "main"}))
+	assert.Equal(t, []string{
+		"GET /repos/owner1/repo1/installation",
+		"POST /app/installations/42/access_tokens",
+		"POST /repos/owner1/repo1/pulls",
+	}, fake.requests)
+}
+
+func Test_App_Token_Org(t *testing.T) {
+	fake, app := newTestApp(t)
+
+	token, err := app.Token("owner1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghs_1", token)
+	assert.Equal(t, "GET /orgs/owner1/installation", fake.requests[0])
+}
+
+func Test_App_Token_Refresh(t *testing.T) {
+	fake, app := newTestApp(t)
+	defer func() { now = time.Now }()
+
+	first, err := app.Token("owner1", "repo1")
+	assert.NoError(t, err)
+	cached, err := app.Token("owner1", "repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, first, cached)
+	assert.Len(t, fake.requests, 2)
+
+	// Installation tokens expire after an hour and are replaced shortly before
+	now = func() time.Time { return time.Now().Add(56 * time.Minute) }
+	refreshed, err := app.Token("owner1", "repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghs_2", refreshed)
+	assert.Equal(t, "POST /app/installations/42/access_tokens", fake.requests[2])
+}
+
+func Test_App_Token_Not_Installed(t *testing.T) {
+	_, app := newTestApp(t)
+	_, err := app.Token("owner2", "repo1")
+	assert.Contains(t, err.Error(), "error finding GitHub App installation for owner2/repo1")
+}
+
+func Test_App_Token_Bad_Key(t *testing.T) {
+	fake, _ := newTestApp(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	other := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	app, err := NewApp(123, other, fake.server.URL)
+	assert.NoError(t, err)
+	_, err = app.Token("owner1", "repo1")
+	assert.Contains(t, err.Error(), "401 A JSON web token could not be verified")
+}
+
+func Test_parsePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	parsed, err := parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	assert.NoError(t, err)
+	assert.Equal(t, key.N, parsed.N)
+
+	_, err = parsePrivateKey([]byte("not a key"))
+	assert.EqualError(t, err, "GitHub App private key is not PEM encoded")
+
+	_, err = parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("foo")}))
+	assert.Contains(t, err.Error(), "error parsing GitHub App private key")
+}
+
+func Test_StaticToken(t *testing.T) {
+	token, err := StaticToken("token123").Token("owner1", "repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, "token123", token)
+}
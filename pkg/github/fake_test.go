@@ -0,0 +1,294 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/v44/github"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeToken = "token123"
+
+// fakeGitHub is a stand-in for the parts of the GitHub API used by this package. It keeps the
+// pull requests and repos of owner1/repo1 in memory and records every request it receives.
+type fakeGitHub struct {
+	server *httptest.Server
+	mu     sync.Mutex
+
+	requests []string
+	// branches are the branches pull requests can be opened from
+	branches map[string]bool
+	pulls    []*github.PullRequest
+	repos    []*github.Repository
+	// rateLimited is the number of requests to reject with a rate limit error before serving any
+	rateLimited int
+	// unknownGets is the number of pull request fetches that report an unknown mergeable state,
+	// as GitHub does until it has checked a new pull request, before mergeableState is reported
+	unknownGets    int
+	mergeableState string
+	graphQLErrors  []string
+	// appKey verifies the JWTs of GitHub App requests, which get installation tokens that are
+	// accepted like fakeToken until they expire
+	appKey             *rsa.PublicKey
+	installationTokens map[string]time.Time
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, *github.Client) {
+	fake := &fakeGitHub{branches: map[string]bool{"file-sync": true}, mergeableState: "blocked", installationTokens: map[string]time.Time{}}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.server.Close)
+
+	client, err := GetClient(fakeToken, fake.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, client
+}
+
+func (f *fakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	if strings.HasPrefix(r.URL.Path, "/app/") || strings.HasSuffix(r.URL.Path, "/installation") {
+		f.app(w, r)
+		return
+	}
+	if !f.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+		return
+	}
+	if f.rateLimited > 0 {
+		f.rateLimited--
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix(), 10))
+		writeJSON(w, http.StatusForbidden, map[string]string{"message": "API rate limit exceeded"})
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/graphql":
+		f.graphQL(w)
+	case len(path) == 3 && path[0] == "orgs" && path[2] == "repos":
+		f.listRepos(w, r)
+	case len(path) >= 4 && path[0] == "repos" && path[1] == "owner1" && path[2] == "repo1":
+		f.repo(w, r, path[3:])
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+}
+
+func (f *fakeGitHub) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if expires, ok := f.installationTokens[token]; ok {
+		return time.Now().Before(expires)
+	}
+	return token == fakeToken
+}
+
+// app serves the GitHub App endpoints, which are authenticated with a JWT signed by the app
+func (f *fakeGitHub) app(w http.ResponseWriter, r *http.Request) {
+	if err := f.verifyJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": err.Error()})
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && (r.URL.Path == "/repos/owner1/repo1/installation" || r.URL.Path == "/orgs/owner1/installation"):
+		writeJSON(w, http.StatusOK, map[string]int{"id": 42})
+	case r.Method == http.MethodPost && r.URL.Path == "/app/installations/42/access_tokens":
+		token := fmt.Sprintf("ghs_%d", len(f.installationTokens)+1)
+		expires := time.Now().Add(time.Hour)
+		f.installationTokens[token] = expires
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"token": token, "expires_at": expires})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+}
+
+func (f *fakeGitHub) verifyJWT(token string) error {
+	parts := strings.Split(token, ".")
+	if f.appKey == nil || len(parts) != 3 {
+		return fmt.Errorf("A JSON web token could not be decoded")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(f.appKey, crypto.SHA256, hash[:], signature); err != nil {
+		return fmt.Errorf("A JSON web token could not be verified")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+	if claims.Issuer != "123" || claims.ExpiresAt-claims.IssuedAt > 600 || time.Now().Unix() > claims.ExpiresAt {
+		return fmt.Errorf("A JSON web token has invalid claims")
+	}
+	return nil
+}
+
+func (f *fakeGitHub) repo(w http.ResponseWriter, r *http.Request, path []string) {
+	route := r.Method + " " + strings.Join(path, "/")
+	switch {
+	case route == "POST pulls":
+		f.createPull(w, r)
+	case route == "GET pulls":
+		var open []*github.PullRequest
+		for _, pr := range f.pulls {
+			if pr.GetState() == "open" && "owner1:"+pr.GetHead().GetRef() == r.URL.Query().Get("head") {
+				open = append(open, pr)
+			}
+		}
+		writeJSON(w, http.StatusOK, open)
+	case len(path) == 2 && path[0] == "pulls" && r.Method == http.MethodGet:
+		pr := f.pull(path[1])
+		if pr == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+			return
+		}
+		pr.MergeableState = github.String(f.mergeableState)
+		if f.unknownGets > 0 {
+			f.unknownGets--
+			pr.MergeableState = github.String("unknown")
+		}
+		writeJSON(w, http.StatusOK, pr)
+	case len(path) == 2 && path[0] == "pulls" && r.Method == http.MethodPatch:
+		pr := f.pull(path[1])
+		var edit github.PullRequest
+		_ = json.NewDecoder(r.Body).Decode(&edit)
+		if edit.Title != nil {
+			pr.Title = edit.Title
+		}
+		if edit.Body != nil {
+			pr.Body = edit.Body
+		}
+		if edit.State != nil {
+			pr.State = edit.State
+		}
+		writeJSON(w, http.StatusOK, pr)
+	case len(path) == 3 && path[0] == "pulls" && path[2] == "merge":
+		f.pull(path[1]).State = github.String("closed")
+		writeJSON(w, http.StatusOK, map[string]bool{"merged": true})
+	case len(path) == 3 && path[0] == "issues" && path[2] == "labels":
+		var labels []string
+		_ = json.NewDecoder(r.Body).Decode(&labels)
+		pr := f.pull(path[1])
+		for _, l := range labels {
+			pr.Labels = append(pr.Labels, &github.Label{Name: github.String(l)})
+		}
+		writeJSON(w, http.StatusOK, pr.Labels)
+	case route == "DELETE git/refs/heads/file-sync":
+		delete(f.branches, "file-sync")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		// Assignees, review requests and comments are recorded but not stored
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	}
+}
+
+func (f *fakeGitHub) createPull(w http.ResponseWriter, r *http.Request) {
+	var newPR github.NewPullRequest
+	_ = json.NewDecoder(r.Body).Decode(&newPR)
+
+	if !f.branches[newPR.GetHead()] {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"message": "Validation Failed",
+			"errors":  []map[string]string{{"resource": "PullRequest", "field": "head", "code": "invalid"}},
+		})
+		return
+	}
+	for _, pr := range f.pulls {
+		if pr.GetState() == "open" && pr.GetHead().GetRef() == newPR.GetHead() {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"message": "Validation Failed",
+				"errors": []map[string]string{{
+					"resource": "PullRequest",
+					"code":     "custom",
+					"message":  fmt.Sprintf("A pull request already exists for owner1:%s.", newPR.GetHead()),
+				}},
+			})
+			return
+		}
+	}
+
+	pr := f.addPull(newPR.GetHead())
+	pr.Title = newPR.Title
+	pr.Body = newPR.Body
+	pr.Draft = newPR.Draft
+	writeJSON(w, http.StatusCreated, pr)
+}
+
+func (f *fakeGitHub) listRepos(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	// Serve one repo per page to exercise pagination
+	if page < len(f.repos) {
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d>; rel="next"`, f.server.URL, r.URL.Path, page+1))
+	}
+	var repos []*github.Repository
+	if page <= len(f.repos) {
+		repos = f.repos[page-1 : page]
+	}
+	writeJSON(w, http.StatusOK, repos)
+}
+
+func (f *fakeGitHub) graphQL(w http.ResponseWriter) {
+	var errors []map[string]string
+	for _, e := range f.graphQLErrors {
+		errors = append(errors, map[string]string{"message": e})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{}, "errors": errors})
+}
+
+// addPull adds an open pull request from head and returns it
+func (f *fakeGitHub) addPull(head string) *github.PullRequest {
+	number := len(f.pulls) + 1
+	pr := &github.PullRequest{
+		Number:  github.Int(number),
+		NodeID:  github.String(fmt.Sprintf("PR_%d", number)),
+		State:   github.String("open"),
+		HTMLURL: github.String(fmt.Sprintf("%s/owner1/repo1/pull/%d", f.server.URL, number)),
+		Head:    &github.PullRequestBranch{Ref: github.String(head)},
+	}
+	f.pulls = append(f.pulls, pr)
+	return pr
+}
+
+func (f *fakeGitHub) pull(number string) *github.PullRequest {
+	for _, pr := range f.pulls {
+		if strconv.Itoa(pr.GetNumber()) == number {
+			return pr
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -2,37 +2,328 @@ package github
 
 import (
 	"context"
+	"fmt"
 	"github.com/google/go-github/v44/github"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
 )
 
-func GetClient(token string) *github.Client {
+const (
+	maxRetries          = 3
+	maxRateLimitWait    = time.Hour
+	defaultAbuseWait    = time.Minute
+	rateLimitResetSlack = time.Second
+)
+
+var sleep = time.Sleep
+
+// GetClient returns a client for the GitHub API at baseURL, or the public API when baseURL is empty
+func GetClient(token, baseURL string) (*github.Client, error) {
 	tokenSource := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
-	httpClient := oauth2.NewClient(context.Background(), tokenSource)
-	return github.NewClient(httpClient)
+	return newClient(oauth2.NewClient(context.Background(), tokenSource), baseURL)
 }
 
-func CreatePullRequest(client *github.Client, owner, repo, title, head, base string) error {
-	log.Infof("creating pull request for %s -> %s", head, base)
-	_, _, err := client.PullRequests.Create(context.Background(), owner, repo, &github.NewPullRequest{
-		Title: github.String(title),
-		Head:  github.String(head),
-		Base:  github.String(base),
-	})
+func newClient(httpClient *http.Client, baseURL string) (*github.Client, error) {
+	client := github.NewClient(httpClient)
+	if baseURL == "" {
+		return client, nil
+	}
+
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	parsed, err := url.Parse(baseURL)
 	if err != nil {
-		if strings.Contains(err.Error(), "A pull request already exists") {
-			log.Info("pull request already open")
-			return nil
+		return nil, fmt.Errorf("invalid GitHub API URL %s: %w", baseURL, err)
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid GitHub API URL %s: scheme and host are required", baseURL)
+	}
+	client.BaseURL = parsed
+	return client, nil
+}
+
+// PullRequest holds the options of a pull request to open
+type PullRequest struct {
+	Title         string
+	Body          string
+	Head          string
+	Base          string
+	Draft         bool
+	Labels        []string
+	Assignees     []string
+	Reviewers     []string
+	TeamReviewers []string
+	// Comment is posted when an existing pull request is updated, unless it is empty
+	Comment string
+	// MergeMethod enables auto-merge with the merge, squash or rebase method, unless it is empty
+	MergeMethod string
+}
+
+// CreatePullRequest opens a pull request, or updates the pull request that is already open for the head branch
+func CreatePullRequest(client *github.Client, owner, repo string, pr PullRequest) error {
+	log.Infof("creating pull request for %s -> %s", pr.Head, pr.Base)
+	var created *github.PullRequest
+	err := withRetry(func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		created, resp, err = client.PullRequests.Create(context.Background(), owner, repo, &github.NewPullRequest{
+			Title: github.String(pr.Title),
+			Body:  github.String(pr.Body),
+			Head:  github.String(pr.Head),
+			Base:  github.String(pr.Base),
+			Draft: github.Bool(pr.Draft),
+		})
+		return resp, err
+	})
+	switch {
+	case err != nil && strings.Contains(err.Error(), "A pull request already exists"):
+		log.Info("pull request already open")
+		if created, err = updatePullRequest(client, owner, repo, pr); err != nil {
+			return err
 		}
-		if strings.Contains(err.Error(), "Field:head Code:invalid Message") {
-			log.Info("pull request not needed")
-			return nil
+	case err != nil && strings.Contains(err.Error(), "Field:head Code:invalid Message"):
+		log.Info("pull request not needed")
+		return nil
+	case err != nil:
+		return err
+	default:
+		log.Infof("created pull request: %s", created.GetHTMLURL())
+		if err := addPullRequestMetadata(client, owner, repo, created.GetNumber(), pr); err != nil {
+			return err
 		}
+	}
+
+	if pr.MergeMethod == "" {
+		return nil
+	}
+	return autoMerge(client, owner, repo, created.GetNumber(), pr.MergeMethod)
+}
+
+// FindPullRequest returns the open pull request from head into base, or nil if there is none
+func FindPullRequest(client *github.Client, owner, repo, head, base string) (*github.PullRequest, error) {
+	var prs []*github.PullRequest
+	err := withRetry(func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		prs, resp, err = client.PullRequests.List(context.Background(), owner, repo, &github.PullRequestListOptions{
+			State: "open",
+			Head:  fmt.Sprintf("%s:%s", owner, head),
+			Base:  base,
+		})
+		return resp, err
+	})
+	if err != nil || len(prs) == 0 {
+		return nil, err
+	}
+	return prs[0], nil
+}
+
+// ClosePullRequest closes the open pull request from head into base with a comment and deletes the
+// head branch. It returns false when there is no open pull request.
+func ClosePullRequest(client *github.Client, owner, repo, head, base, comment string) (bool, error) {
+	pr, err := FindPullRequest(client, owner, repo, head, base)
+	if err != nil || pr == nil {
+		return false, err
+	}
+
+	number := pr.GetNumber()
+	log.Infof("closing pull request #%d", number)
+	ctx := context.Background()
+	err = withRetry(func() (*github.Response, error) {
+		_, resp, err := client.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{Body: github.String(comment)})
+		return resp, err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error commenting on pull request #%d: %w", number, err)
+	}
+	err = withRetry(func() (*github.Response, error) {
+		_, resp, err := client.PullRequests.Edit(ctx, owner, repo, number, &github.PullRequest{State: github.String("closed")})
+		return resp, err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error closing pull request #%d: %w", number, err)
+	}
+	err = withRetry(func() (*github.Response, error) {
+		return client.Git.DeleteRef(ctx, owner, repo, "heads/"+head)
+	})
+	if err != nil {
+		return true, fmt.Errorf("error deleting branch %s: %w", head, err)
+	}
+	return true, nil
+}
+
+// updatePullRequest updates the title, body and labels of the open pull request for pr.Head and
+// posts pr.Comment on it
+func updatePullRequest(client *github.Client, owner, repo string, pr PullRequest) (*github.PullRequest, error) {
+	existing, err := FindPullRequest(client, owner, repo, pr.Head, pr.Base)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("no open pull request found for %s -> %s", pr.Head, pr.Base)
+	}
+
+	number := existing.GetNumber()
+	log.Infof("updating pull request #%d", number)
+	err = withRetry(func() (*github.Response, error) {
+		_, resp, err := client.PullRequests.Edit(context.Background(), owner, repo, number, &github.PullRequest{
+			Title: github.String(pr.Title),
+			Body:  github.String(pr.Body),
+		})
+		return resp, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating pull request #%d: %w", number, err)
+	}
+	if err := addLabels(client, owner, repo, number, pr.Labels); err != nil {
+		return nil, err
+	}
+
+	if pr.Comment == "" {
+		return existing, nil
+	}
+	err = withRetry(func() (*github.Response, error) {
+		_, resp, err := client.Issues.CreateComment(context.Background(), owner, repo, number, &github.IssueComment{
+			Body: github.String(pr.Comment),
+		})
+		return resp, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error commenting on pull request #%d: %w", number, err)
+	}
+	return existing, nil
+}
+
+// addPullRequestMetadata adds the labels, assignees and review requests of pr to a pull request
+func addPullRequestMetadata(client *github.Client, owner, repo string, number int, pr PullRequest) error {
+	ctx := context.Background()
+	if err := addLabels(client, owner, repo, number, pr.Labels); err != nil {
 		return err
 	}
+	if len(pr.Assignees) > 0 {
+		err := withRetry(func() (*github.Response, error) {
+			_, resp, err := client.Issues.AddAssignees(ctx, owner, repo, number, pr.Assignees)
+			return resp, err
+		})
+		if err != nil {
+			return fmt.Errorf("error adding assignees to pull request #%d: %w", number, err)
+		}
+	}
+	if len(pr.Reviewers) > 0 || len(pr.TeamReviewers) > 0 {
+		err := withRetry(func() (*github.Response, error) {
+			_, resp, err := client.PullRequests.RequestReviewers(ctx, owner, repo, number, github.ReviewersRequest{
+				Reviewers:     pr.Reviewers,
+				TeamReviewers: pr.TeamReviewers,
+			})
+			return resp, err
+		})
+		if err != nil {
+			return fmt.Errorf("error requesting reviewers for pull request #%d: %w", number, err)
+		}
+	}
 	return nil
 }
+
+func addLabels(client *github.Client, owner, repo string, number int, labels []string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	err := withRetry(func() (*github.Response, error) {
+		_, resp, err := client.Issues.AddLabelsToIssue(context.Background(), owner, repo, number, labels)
+		return resp, err
+	})
+	if err != nil {
+		return fmt.Errorf("error adding labels to pull request #%d: %w", number, err)
+	}
+	return nil
+}
+
+// ListRepos returns the full names of the non-archived repositories in org that have the topic and
+// whose name matches pattern. Empty topic and pattern values match all repositories.
+func ListRepos(client *github.Client, org, topic, pattern string) ([]string, error) {
+	log.Infof("listing repositories in %s", org)
+	nameRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []string
+	opts := &github.RepositoryListByOrgOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		var page []*github.Repository
+		var resp *github.Response
+		err := withRetry(func() (*github.Response, error) {
+			var err error
+			page, resp, err = client.Repositories.ListByOrg(context.Background(), org, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page {
+			if r.GetArchived() || !hasTopic(r, topic) || !nameRegexp.MatchString(r.GetName()) {
+				continue
+			}
+			repos = append(repos, r.GetFullName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	log.Debugf("found repositories: %s", repos)
+	return repos, nil
+}
+
+func hasTopic(repo *github.Repository, topic string) bool {
+	if topic == "" {
+		return true
+	}
+	for _, t := range repo.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry runs an API call and retries it when GitHub responds with a rate limit error
+func withRetry(call func() (*github.Response, error)) error {
+	for attempt := 1; ; attempt++ {
+		_, err := call()
+		wait, limited := rateLimitWait(err)
+		if !limited || attempt > maxRetries {
+			return err
+		}
+		log.Warningf("GitHub rate limit reached, retrying in %s", wait)
+		sleep(wait)
+	}
+}
+
+func rateLimitWait(err error) (time.Duration, bool) {
+	switch e := err.(type) {
+	case *github.RateLimitError:
+		wait := time.Until(e.Rate.Reset.Time) + rateLimitResetSlack
+		if wait > maxRateLimitWait {
+			wait = maxRateLimitWait
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	case *github.AbuseRateLimitError:
+		if e.RetryAfter != nil {
+			return *e.RetryAfter, true
+		}
+		return defaultAbuseWait, true
+	}
+	return 0, false
+}
@@ -1,21 +1,250 @@
 package github
 
 import (
+	"fmt"
 	"github.com/google/go-github/v44/github"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func Test_GetClient(t *testing.T) {
-	assert.NotNil(t, GetClient("token123"))
+	client, err := GetClient("token123", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.github.com/", client.BaseURL.String())
+
+	client, err = GetClient("token123", "https://github.example.com/api/v3")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://github.example.com/api/v3/", client.BaseURL.String())
+}
+
+func Test_GetClient_Invalid_URL(t *testing.T) {
+	_, err := GetClient("token123", "github.example.com")
+	assert.Contains(t, err.Error(), "scheme and host are required")
+
+	_, err = GetClient("token123", "https://github.example.com/%zz")
+	assert.Contains(t, err.Error(), "invalid GitHub API URL")
 }
 
 func Test_CreatePullRequest(t *testing.T) {
-	client := github.NewClient(nil)
-	err := CreatePullRequest(client, "owner1", "repo1", "my pull request", "test-branch", "main")
+	fake, client := newFakeGitHub(t)
+
+	err := CreatePullRequest(client, "owner1", "repo1", PullRequest{
+		Title:         "my pull request",
+		Body:          "my body",
+		Head:          "file-sync",
+		Base:          "main",
+		Draft:         true,
+		Labels:        []string{"label1"},
+		Assignees:     []string{"user1"},
+		Reviewers:     []string{"user2"},
+		TeamReviewers: []string{"team1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"POST /repos/owner1/repo1/pulls",
+		"POST /repos/owner1/repo1/issues/1/labels",
+		"POST /repos/owner1/repo1/issues/1/assignees",
+		"POST /repos/owner1/repo1/pulls/1/requested_reviewers",
+	}, fake.requests)
+	assert.Len(t, fake.pulls, 1)
+	assert.Equal(t, "my pull request", fake.pulls[0].GetTitle())
+	assert.True(t, fake.pulls[0].GetDraft())
+	assert.Equal(t, "label1", fake.pulls[0].Labels[0].GetName())
+}
+
+func Test_CreatePullRequest_Already_Open(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+
+	err := CreatePullRequest(client, "owner1", "repo1", PullRequest{Title: "new title", Body: "new body", Head: "file-sync", Base: "main", Comment: "updated"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"POST /repos/owner1/repo1/pulls",
+		"GET /repos/owner1/repo1/pulls",
+		"PATCH /repos/owner1/repo1/pulls/1",
+		"POST /repos/owner1/repo1/issues/1/comments",
+	}, fake.requests)
+	assert.Len(t, fake.pulls, 1)
+	assert.Equal(t, "new title", fake.pulls[0].GetTitle())
+	assert.Equal(t, "new body", fake.pulls[0].GetBody())
+}
+
+func Test_CreatePullRequest_Invalid_Head(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+
+	err := CreatePullRequest(client, "owner1", "repo1", PullRequest{Title: "title", Head: "not-a-branch", Base: "main", MergeMethod: "squash"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /repos/owner1/repo1/pulls"}, fake.requests)
+	assert.Empty(t, fake.pulls)
+}
+
+func Test_CreatePullRequest_Auto_Merge(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+
+	err := CreatePullRequest(client, "owner1", "repo1", PullRequest{Title: "title", Head: "file-sync", Base: "main", MergeMethod: "squash"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"POST /repos/owner1/repo1/pulls",
+		"GET /repos/owner1/repo1/pulls/1",
+		"POST /graphql",
+	}, fake.requests)
+}
+
+func Test_CreatePullRequest_Rate_Limit(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.rateLimited = 2
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	err := CreatePullRequest(client, "owner1", "repo1", PullRequest{Title: "title", Head: "file-sync", Base: "main"})
+	assert.NoError(t, err)
+	assert.Len(t, waits, 2)
+	assert.Len(t, fake.pulls, 1)
+}
+
+func Test_CreatePullRequest_Bad_Credentials(t *testing.T) {
+	fake, _ := newFakeGitHub(t)
+	client, err := GetClient("bad-token", fake.server.URL)
+	assert.NoError(t, err)
+
+	err = CreatePullRequest(client, "owner1", "repo1", PullRequest{Title: "title", Head: "file-sync", Base: "main"})
+	assert.Contains(t, err.Error(), "401 Bad credentials")
+	assert.Empty(t, fake.pulls)
+}
+
+func Test_CreatePullRequest_Not_Found(t *testing.T) {
+	_, client := newFakeGitHub(t)
+	err := CreatePullRequest(client, "owner1", "not-a-repo", PullRequest{Title: "title", Head: "file-sync", Base: "main"})
 	assert.Contains(t, err.Error(), "404 Not Found")
 }
 
-func Test_CreatePullRequest_Already_Open(t *testing.T) {}
+func Test_updatePullRequest(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+
+	pr := PullRequest{Title: "title", Body: "body", Head: "file-sync", Base: "main", Labels: []string{"label1"}, Comment: "comment"}
+	updated, err := updatePullRequest(client, "owner1", "repo1", pr)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, updated.GetNumber())
+	assert.Equal(t, []string{
+		"GET /repos/owner1/repo1/pulls",
+		"PATCH /repos/owner1/repo1/pulls/1",
+		"POST /repos/owner1/repo1/issues/1/labels",
+		"POST /repos/owner1/repo1/issues/1/comments",
+	}, fake.requests)
+}
+
+func Test_updatePullRequest_Not_Found(t *testing.T) {
+	_, client := newFakeGitHub(t)
+	_, err := updatePullRequest(client, "owner1", "repo1", PullRequest{Head: "file-sync", Base: "main"})
+	assert.EqualError(t, err, "no open pull request found for file-sync -> main")
+}
 
-func Test_CreatePullRequest_Invalid_Head(t *testing.T) {}
+func Test_ClosePullRequest(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+
+	closed, err := ClosePullRequest(client, "owner1", "repo1", "file-sync", "main", "no longer needed")
+	assert.NoError(t, err)
+	assert.True(t, closed)
+	assert.Equal(t, "closed", fake.pulls[0].GetState())
+	assert.False(t, fake.branches["file-sync"])
+	assert.Contains(t, fake.requests, "POST /repos/owner1/repo1/issues/1/comments")
+}
+
+func Test_ClosePullRequest_None_Open(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+
+	closed, err := ClosePullRequest(client, "owner1", "repo1", "file-sync", "main", "no longer needed")
+	assert.NoError(t, err)
+	assert.False(t, closed)
+	assert.Equal(t, []string{"GET /repos/owner1/repo1/pulls"}, fake.requests)
+}
+
+func Test_ListRepos(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.repos = []*github.Repository{
+		{Name: github.String("terraform-aws"), FullName: github.String("owner1/terraform-aws"), Topics: []string{"terraform"}},
+		{Name: github.String("terraform-old"), FullName: github.String("owner1/terraform-old"), Topics: []string{"terraform"}, Archived: github.Bool(true)},
+		{Name: github.String("terraform-gcp"), FullName: github.String("owner1/terraform-gcp")},
+		{Name: github.String("website"), FullName: github.String("owner1/website"), Topics: []string{"terraform"}},
+	}
+
+	repos, err := ListRepos(client, "owner1", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"owner1/terraform-aws", "owner1/terraform-gcp", "owner1/website"}, repos)
+	assert.Len(t, fake.requests, 4)
+
+	repos, err = ListRepos(client, "owner1", "terraform", "^terraform-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"owner1/terraform-aws"}, repos)
+}
+
+func Test_ListRepos_Bad_Credentials(t *testing.T) {
+	fake, _ := newFakeGitHub(t)
+	client, _ := GetClient("bad-token", fake.server.URL)
+	_, err := ListRepos(client, "owner1", "", "")
+	assert.Contains(t, err.Error(), "401 Bad credentials")
+}
+
+func Test_ListRepos_Invalid_Pattern(t *testing.T) {
+	_, client := newFakeGitHub(t)
+	_, err := ListRepos(client, "owner1", "", "(")
+	assert.Error(t, err)
+}
+
+func Test_hasTopic(t *testing.T) {
+	repo := &github.Repository{Topics: []string{"terraform", "aws"}}
+	assert.True(t, hasTopic(repo, ""))
+	assert.True(t, hasTopic(repo, "aws"))
+	assert.False(t, hasTopic(repo, "gcp"))
+}
+
+func Test_withRetry_Rate_Limit(t *testing.T) {
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	calls := 0
+	err := withRetry(func() (*github.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now()}}}
+		}
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Len(t, waits, 1)
+}
+
+func Test_withRetry_Abuse_Limit(t *testing.T) {
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	retryAfter := 5 * time.Second
+	err := withRetry(func() (*github.Response, error) {
+		return nil, &github.AbuseRateLimitError{RetryAfter: &retryAfter}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []time.Duration{retryAfter, retryAfter, retryAfter}, waits)
+}
+
+func Test_withRetry_Other_Error(t *testing.T) {
+	calls := 0
+	err := withRetry(func() (*github.Response, error) {
+		calls++
+		return nil, fmt.Errorf("test error")
+	})
+	assert.EqualError(t, err, "test error")
+	assert.Equal(t, 1, calls)
+}
+
+func Test_rateLimitWait_Capped(t *testing.T) {
+	wait, limited := rateLimitWait(&github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(2 * time.Hour)}}})
+	assert.True(t, limited)
+	assert.Equal(t, maxRateLimitWait, wait)
+}
@@ -0,0 +1,130 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-github/v44/github"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	// mergeableStatePolls is how many times a pull request is fetched while GitHub is still
+	// computing whether it is mergeable, which it does in the background after it is opened
+	mergeableStatePolls = 5
+	mergeableStateWait  = 2 * time.Second
+)
+
+// mergeableStates can be merged right away: clean has passed all checks, unstable has failing
+// checks that are not required and has_hooks has passed checks but has pre-receive hooks
+var mergeableStates = map[string]bool{"clean": true, "unstable": true, "has_hooks": true}
+
+const enableAutoMergeMutation = `mutation($id: ID!, $method: PullRequestMergeMethod!) {
+  enablePullRequestAutoMerge(input: {pullRequestId: $id, mergeMethod: $method}) {
+    clientMutationId
+  }
+}`
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLResponse struct {
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// autoMerge merges a pull request right away when it is mergeable and its required checks have
+// passed, and otherwise enables auto-merge so that GitHub merges it once they pass
+func autoMerge(client *github.Client, owner, repo string, number int, method string) error {
+	pr, err := getMergeablePullRequest(client, owner, repo, number)
+	if err != nil {
+		return err
+	}
+	if mergeableStates[pr.GetMergeableState()] {
+		return mergePullRequest(client, owner, repo, number, method)
+	}
+
+	log.Infof("enabling auto-merge for pull request #%d", number)
+	if err := enableAutoMerge(client, pr.GetNodeID(), method); err != nil {
+		// GitHub refuses auto-merge when nothing is left to wait for
+		if strings.Contains(strings.ToLower(err.Error()), "clean status") {
+			return mergePullRequest(client, owner, repo, number, method)
+		}
+		return fmt.Errorf("error enabling auto-merge for pull request #%d: %w", number, err)
+	}
+	return nil
+}
+
+// getMergeablePullRequest fetches a pull request until its mergeable state is no longer unknown,
+// or the polls run out
+func getMergeablePullRequest(client *github.Client, owner, repo string, number int) (*github.PullRequest, error) {
+	var pr *github.PullRequest
+	for poll := 1; ; poll++ {
+		err := withRetry(func() (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			pr, resp, err = client.PullRequests.Get(context.Background(), owner, repo, number)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if pr.GetMergeableState() != "unknown" || poll >= mergeableStatePolls {
+			return pr, nil
+		}
+		log.Debugf("waiting for GitHub to check if pull request #%d is mergeable", number)
+		sleep(mergeableStateWait)
+	}
+}
+
+func mergePullRequest(client *github.Client, owner, repo string, number int, method string) error {
+	log.Infof("merging pull request #%d", number)
+	err := withRetry(func() (*github.Response, error) {
+		_, resp, err := client.PullRequests.Merge(context.Background(), owner, repo, number, "", &github.PullRequestOptions{MergeMethod: method})
+		return resp, err
+	})
+	if err != nil {
+		return fmt.Errorf("error merging pull request #%d: %w", number, err)
+	}
+	return nil
+}
+
+// enableAutoMerge runs the enablePullRequestAutoMerge mutation, which has no REST equivalent
+func enableAutoMerge(client *github.Client, nodeID, method string) error {
+	var resp graphQLResponse
+	err := withRetry(func() (*github.Response, error) {
+		req, err := client.NewRequest("POST", graphQLURL(client), graphQLRequest{
+			Query:     enableAutoMergeMutation,
+			Variables: map[string]interface{}{"id": nodeID, "method": strings.ToUpper(method)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return client.Do(context.Background(), req, &resp)
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		var messages []string
+		for _, e := range resp.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// graphQLURL returns the GraphQL endpoint, which is /api/graphql rather than /api/v3/graphql on
+// GitHub Enterprise Server
+func graphQLURL(client *github.Client) string {
+	base := client.BaseURL.String()
+	if strings.HasSuffix(base, "/api/v3/") {
+		return strings.TrimSuffix(base, "v3/") + "graphql"
+	}
+	return base + "graphql"
+}
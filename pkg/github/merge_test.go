@@ -0,0 +1,113 @@
+package github
+
+import (
+	"github.com/google/go-github/v44/github"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_autoMerge_Clean(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+	fake.mergeableState = "clean"
+
+	assert.NoError(t, autoMerge(client, "owner1", "repo1", 1, "squash"))
+	assert.Equal(t, []string{
+		"GET /repos/owner1/repo1/pulls/1",
+		"PUT /repos/owner1/repo1/pulls/1/merge",
+	}, fake.requests)
+	assert.Equal(t, "closed", fake.pulls[0].GetState())
+}
+
+func Test_autoMerge_Unknown_Then_Clean(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+	fake.unknownGets = 2
+	fake.mergeableState = "clean"
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	assert.NoError(t, autoMerge(client, "owner1", "repo1", 1, "squash"))
+	assert.Equal(t, []string{
+		"GET /repos/owner1/repo1/pulls/1",
+		"GET /repos/owner1/repo1/pulls/1",
+		"GET /repos/owner1/repo1/pulls/1",
+		"PUT /repos/owner1/repo1/pulls/1/merge",
+	}, fake.requests)
+	assert.Equal(t, []time.Duration{mergeableStateWait, mergeableStateWait}, waits)
+	assert.Equal(t, "closed", fake.pulls[0].GetState())
+}
+
+func Test_autoMerge_Unknown_Polls_Run_Out(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+	fake.unknownGets = mergeableStatePolls
+	sleep = func(d time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	assert.NoError(t, autoMerge(client, "owner1", "repo1", 1, "rebase"))
+	assert.Len(t, fake.requests, mergeableStatePolls+1)
+	assert.Equal(t, "POST /graphql", fake.requests[mergeableStatePolls])
+	assert.Equal(t, "open", fake.pulls[0].GetState())
+}
+
+func Test_autoMerge_Unstable(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+	fake.mergeableState = "unstable"
+
+	assert.NoError(t, autoMerge(client, "owner1", "repo1", 1, "merge"))
+	assert.Equal(t, "closed", fake.pulls[0].GetState())
+}
+
+func Test_autoMerge_Enable_Clean_Status(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+	fake.graphQLErrors = []string{"Pull request is in clean status"}
+
+	assert.NoError(t, autoMerge(client, "owner1", "repo1", 1, "merge"))
+	assert.Equal(t, []string{
+		"GET /repos/owner1/repo1/pulls/1",
+		"POST /graphql",
+		"PUT /repos/owner1/repo1/pulls/1/merge",
+	}, fake.requests)
+	assert.Equal(t, "closed", fake.pulls[0].GetState())
+}
+
+func Test_autoMerge_Enable(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+
+	assert.NoError(t, autoMerge(client, "owner1", "repo1", 1, "rebase"))
+	assert.Equal(t, []string{
+		"GET /repos/owner1/repo1/pulls/1",
+		"POST /graphql",
+	}, fake.requests)
+	assert.Equal(t, "open", fake.pulls[0].GetState())
+}
+
+func Test_autoMerge_Enable_Error(t *testing.T) {
+	fake, client := newFakeGitHub(t)
+	fake.addPull("file-sync")
+	fake.graphQLErrors = []string{"Auto merge is not allowed for this repository"}
+
+	err := autoMerge(client, "owner1", "repo1", 1, "merge")
+	assert.EqualError(t, err, "error enabling auto-merge for pull request #1: Auto merge is not allowed for this repository")
+}
+
+func Test_autoMerge_Not_Found(t *testing.T) {
+	_, client := newFakeGitHub(t)
+	err := autoMerge(client, "owner1", "repo1", 1, "merge")
+	assert.Contains(t, err.Error(), "404 Not Found")
+}
+
+func Test_graphQLURL(t *testing.T) {
+	client := github.NewClient(nil)
+	assert.Equal(t, "https://api.github.com/graphql", graphQLURL(client))
+
+	client.BaseURL, _ = url.Parse("https://github.example.com/api/v3/")
+	assert.Equal(t, "https://github.example.com/api/graphql", graphQLURL(client))
+}
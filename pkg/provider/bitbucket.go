@@ -0,0 +1,233 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"github.com/champ-oss/file-sync/pkg/common"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// bitbucketUsername is the basic auth user for repository, project and workspace access tokens
+const bitbucketUsername = "x-token-auth"
+
+// bitbucketMergeStrategies maps merge methods to Bitbucket merge strategies
+var bitbucketMergeStrategies = map[string]string{
+	"merge":  "merge_commit",
+	"squash": "squash",
+	"rebase": "fast_forward",
+}
+
+// Bitbucket opens pull requests on Bitbucket Cloud
+type Bitbucket struct {
+	serverURL string
+	token     string
+	api       *restClient
+}
+
+type bitbucketPullRequest struct {
+	ID    int `json:"id"`
+	Links struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+type bitbucketBranch struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+}
+
+func NewBitbucket(serverURL, apiURL, token string) *Bitbucket {
+	return &Bitbucket{serverURL: serverURL, token: token, api: newRESTClient(apiURL, func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	})}
+}
+
+func (b *Bitbucket) CloneURL(repo string) string {
+	return repoURL(b.serverURL, repo) + ".git"
+}
+
+func (b *Bitbucket) Credentials(_, _ string) (common.Credentials, error) {
+	return common.Credentials{Username: bitbucketUsername, Password: b.token}, nil
+}
+
+func (b *Bitbucket) CreatePullRequest(owner, repo string, pr PullRequest) error {
+	log.Infof("creating pull request for %s -> %s", pr.Head, pr.Base)
+	if len(pr.Labels) > 0 || len(pr.Assignees) > 0 || len(pr.TeamReviewers) > 0 {
+		log.Warning("labels, assignees and team reviewers are not supported by Bitbucket")
+	}
+	existing, err := b.findPullRequest(owner, repo, pr.Head, pr.Base)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{"title": pr.Title, "description": pr.Body}
+	var id int
+	if existing == nil {
+		var source, destination bitbucketBranch
+		source.Branch.Name = pr.Head
+		destination.Branch.Name = pr.Base
+		fields["source"] = source
+		fields["destination"] = destination
+		fields["draft"] = pr.Draft
+		fields["reviewers"] = bitbucketUsers(pr.Reviewers)
+
+		created := &bitbucketPullRequest{}
+		if _, err := b.api.do(http.MethodPost, repoPath(owner, repo)+"/pullrequests", fields, created); err != nil {
+			return fmt.Errorf("error creating pull request: %w", err)
+		}
+		log.Infof("created pull request: %s", created.Links.HTML.Href)
+		id = created.ID
+	} else {
+		id = existing.ID
+		if _, err := b.api.do(http.MethodPut, pullRequestPath(owner, repo, id), fields, nil); err != nil {
+			return fmt.Errorf("error updating pull request #%d: %w", id, err)
+		}
+		log.Infof("updated pull request: %s", existing.Links.HTML.Href)
+		if pr.Comment != "" {
+			if err := b.comment(owner, repo, id, pr.Comment); err != nil {
+				return err
+			}
+		}
+	}
+
+	if pr.MergeMethod != "" {
+		return b.merge(owner, repo, id, pr.MergeMethod)
+	}
+	return nil
+}
+
+func (b *Bitbucket) ClosePullRequest(owner, repo, head, base, comment string) (bool, error) {
+	pr, err := b.findPullRequest(owner, repo, head, base)
+	if err != nil || pr == nil {
+		return false, err
+	}
+
+	log.Infof("declining pull request #%d", pr.ID)
+	if err := b.comment(owner, repo, pr.ID, comment); err != nil {
+		return false, err
+	}
+	if _, err := b.api.do(http.MethodPost, pullRequestPath(owner, repo, pr.ID)+"/decline", nil, nil); err != nil {
+		return false, fmt.Errorf("error declining pull request #%d: %w", pr.ID, err)
+	}
+	if _, err := b.api.do(http.MethodDelete, repoPath(owner, repo)+"/refs/branches/"+url.PathEscape(head), nil, nil); err != nil {
+		return true, fmt.Errorf("error deleting branch %s: %w", head, err)
+	}
+	return true, nil
+}
+
+// ListRepos returns the repos in a workspace. Bitbucket repos have no topics, so filtering by
+// topic is not supported.
+func (b *Bitbucket) ListRepos(org, topic, pattern string) ([]string, error) {
+	if topic != "" {
+		return nil, fmt.Errorf("filtering repositories by topic is not supported by Bitbucket")
+	}
+	log.Infof("listing repositories in %s", org)
+	nameRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []string
+	next := fmt.Sprintf("/repositories/%s?pagelen=100", url.PathEscape(org))
+	for next != "" {
+		var page struct {
+			Values []struct {
+				Slug     string `json:"slug"`
+				FullName string `json:"full_name"`
+			} `json:"values"`
+			Next string `json:"next"`
+		}
+		if _, err := b.api.do(http.MethodGet, next, nil, &page); err != nil {
+			return nil, fmt.Errorf("error listing repositories in %s: %w", org, err)
+		}
+		for _, r := range page.Values {
+			if nameRegexp.MatchString(r.Slug) {
+				repos = append(repos, r.FullName)
+			}
+		}
+		next = page.Next
+	}
+	log.Debugf("found repositories: %s", repos)
+	return repos, nil
+}
+
+func (b *Bitbucket) findPullRequest(owner, repo, head, base string) (*bitbucketPullRequest, error) {
+	query := url.Values{
+		"state": {"OPEN"},
+		"q":     {fmt.Sprintf(`source.branch.name = %q AND destination.branch.name = %q`, head, base)},
+	}
+	var page struct {
+		Values []*bitbucketPullRequest `json:"values"`
+	}
+	if _, err := b.api.do(http.MethodGet, repoPath(owner, repo)+"/pullrequests?"+query.Encode(), nil, &page); err != nil {
+		return nil, fmt.Errorf("error finding pull request for %s -> %s: %w", head, base, err)
+	}
+	if len(page.Values) == 0 {
+		return nil, nil
+	}
+	return page.Values[0], nil
+}
+
+func (b *Bitbucket) comment(owner, repo string, id int, body string) error {
+	fields := map[string]interface{}{"content": map[string]string{"raw": body}}
+	if _, err := b.api.do(http.MethodPost, pullRequestPath(owner, repo, id)+"/comments", fields, nil); err != nil {
+		return fmt.Errorf("error commenting on pull request #%d: %w", id, err)
+	}
+	return nil
+}
+
+// merge merges the pull request right away, since Bitbucket Cloud has no auto-merge. A pull
+// request that is blocked by merge checks is left open to be merged by a later run.
+func (b *Bitbucket) merge(owner, repo string, id int, method string) error {
+	log.Infof("merging pull request #%d", id)
+	fields := map[string]interface{}{"merge_strategy": bitbucketMergeStrategies[method], "close_source_branch": true}
+	_, err := b.api.do(http.MethodPost, pullRequestPath(owner, repo, id)+"/merge", fields, nil)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && isMergeBlocked(apiErr) {
+		log.Warningf("pull request #%d cannot be merged yet: %s", id, apiErr.Message)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error merging pull request #%d: %w", id, err)
+	}
+	return nil
+}
+
+// isMergeBlocked reports whether a merge was refused because of failed merge checks or conflicts,
+// rather than because of the token or the repo
+func isMergeBlocked(err *apiError) bool {
+	if err.StatusCode != http.StatusBadRequest && err.StatusCode != http.StatusConflict {
+		return false
+	}
+	message := strings.ToLower(err.Message)
+	return strings.Contains(message, "merge check") || strings.Contains(message, "conflict")
+}
+
+// bitbucketUsers returns the reviewers of a pull request, which are identified by their account
+// id or their {uuid}
+func bitbucketUsers(users []string) []map[string]string {
+	reviewers := []map[string]string{}
+	for _, u := range users {
+		if strings.HasPrefix(u, "{") {
+			reviewers = append(reviewers, map[string]string{"uuid": u})
+		} else {
+			reviewers = append(reviewers, map[string]string{"account_id": u})
+		}
+	}
+	return reviewers
+}
+
+func repoPath(owner, repo string) string {
+	return fmt.Sprintf("/repositories/%s/%s", url.PathEscape(owner), url.PathEscape(repo))
+}
+
+func pullRequestPath(owner, repo string, id int) string {
+	return fmt.Sprintf("%s/pullrequests/%d", repoPath(owner, repo), id)
+}
@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+const testRepoPath = "/repositories/owner1/repo1"
+
+func Test_Bitbucket_CreatePullRequest(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testRepoPath + "/pullrequests":          {body: `{"values": []}`},
+		"POST " + testRepoPath + "/pullrequests":         {status: http.StatusCreated, body: `{"id": 5, "links": {"html": {"href": "https://bitbucket.org/owner1/repo1/pull-requests/5"}}}`},
+		"POST " + testRepoPath + "/pullrequests/5/merge": {body: `{}`},
+	})
+	bitbucket := NewBitbucket("https://bitbucket.org", fake.server.URL, "token123")
+
+	err := bitbucket.CreatePullRequest("owner1", "repo1", PullRequest{
+		Title:       "file-sync",
+		Body:        "body",
+		Head:        "file-sync",
+		Base:        "main",
+		Draft:       true,
+		Labels:      []string{"label1"},
+		Reviewers:   []string{"557058:c0b72ad0", "{d301aafa-d676-4ee0-88be-962be7417567}"},
+		MergeMethod: "rebase",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET " + testRepoPath + "/pullrequests",
+		"POST " + testRepoPath + "/pullrequests",
+		"POST " + testRepoPath + "/pullrequests/5/merge",
+	}, fake.requests)
+	assert.Equal(t, "Bearer token123", fake.header.Get("Authorization"))
+	assert.Equal(t, map[string]interface{}{
+		"title":       "file-sync",
+		"description": "body",
+		"source":      map[string]interface{}{"branch": map[string]interface{}{"name": "file-sync"}},
+		"destination": map[string]interface{}{"branch": map[string]interface{}{"name": "main"}},
+		"draft":       true,
+		"reviewers": []interface{}{
+			map[string]interface{}{"account_id": "557058:c0b72ad0"},
+			map[string]interface{}{"uuid": "{d301aafa-d676-4ee0-88be-962be7417567}"},
+		},
+	}, fake.body("POST "+testRepoPath+"/pullrequests"))
+	assert.Equal(t, map[string]interface{}{"merge_strategy": "fast_forward", "close_source_branch": true}, fake.body("POST "+testRepoPath+"/pullrequests/5/merge"))
+}
+
+func Test_Bitbucket_CreatePullRequest_Already_Open(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testRepoPath + "/pullrequests":             {body: `{"values": [{"id": 5}]}`},
+		"PUT " + testRepoPath + "/pullrequests/5":           {body: `{"id": 5}`},
+		"POST " + testRepoPath + "/pullrequests/5/comments": {status: http.StatusCreated, body: `{}`},
+	})
+	bitbucket := NewBitbucket("https://bitbucket.org", fake.server.URL, "token123")
+
+	err := bitbucket.CreatePullRequest("owner1", "repo1", PullRequest{Title: "new title", Body: "new body", Head: "file-sync", Base: "main", Comment: "updated"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET " + testRepoPath + "/pullrequests",
+		"PUT " + testRepoPath + "/pullrequests/5",
+		"POST " + testRepoPath + "/pullrequests/5/comments",
+	}, fake.requests)
+	assert.Equal(t, map[string]interface{}{"title": "new title", "description": "new body"}, fake.body("PUT "+testRepoPath+"/pullrequests/5"))
+	assert.Equal(t, map[string]interface{}{"content": map[string]interface{}{"raw": "updated"}}, fake.body("POST "+testRepoPath+"/pullrequests/5/comments"))
+}
+
+func Test_Bitbucket_CreatePullRequest_Merge_Blocked(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testRepoPath + "/pullrequests":          {body: `{"values": [{"id": 5}]}`},
+		"PUT " + testRepoPath + "/pullrequests/5":        {body: `{"id": 5}`},
+		"POST " + testRepoPath + "/pullrequests/5/merge": {status: http.StatusBadRequest, body: `{"error": {"message": "Merge checks failed"}}`},
+	})
+	bitbucket := NewBitbucket("https://bitbucket.org", fake.server.URL, "token123")
+
+	err := bitbucket.CreatePullRequest("owner1", "repo1", PullRequest{Head: "file-sync", Base: "main", MergeMethod: "squash"})
+	assert.NoError(t, err)
+	assert.Contains(t, fake.requests, "POST "+testRepoPath+"/pullrequests/5/merge")
+}
+
+func Test_Bitbucket_CreatePullRequest_Merge_Forbidden(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testRepoPath + "/pullrequests":          {body: `{"values": [{"id": 5}]}`},
+		"PUT " + testRepoPath + "/pullrequests/5":        {body: `{"id": 5}`},
+		"POST " + testRepoPath + "/pullrequests/5/merge": {status: http.StatusForbidden, body: `{"error": {"message": "Your credentials lack one or more required privilege scopes."}}`},
+	})
+	bitbucket := NewBitbucket("https://bitbucket.org", fake.server.URL, "token123")
+
+	err := bitbucket.CreatePullRequest("owner1", "repo1", PullRequest{Head: "file-sync", Base: "main", MergeMethod: "squash"})
+	assert.Contains(t, err.Error(), "error merging pull request #5")
+	assert.Contains(t, err.Error(), "403")
+}
+
+func Test_Bitbucket_CreatePullRequest_Error(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testRepoPath + "/pullrequests":  {body: `{"values": []}`},
+		"POST " + testRepoPath + "/pullrequests": {status: http.StatusBadRequest, body: `{"error": {"message": "source branch not found"}}`},
+	})
+	bitbucket := NewBitbucket("https://bitbucket.org", fake.server.URL, "token123")
+
+	err := bitbucket.CreatePullRequest("owner1", "repo1", PullRequest{Head: "file-sync", Base: "main"})
+	assert.Contains(t, err.Error(), "error creating pull request")
+	assert.Contains(t, err.Error(), "source branch not found")
+}
+
+func Test_Bitbucket_ClosePullRequest(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testRepoPath + "/pullrequests?q=source.branch.name+%3D+%22file-sync%22+AND+destination.branch.name+%3D+%22main%22&state=OPEN": {body: `{"values": [{"id": 5}]}`},
+		"POST " + testRepoPath + "/pullrequests/5/comments":   {status: http.StatusCreated, body: `{}`},
+		"POST " + testRepoPath + "/pullrequests/5/decline":    {body: `{}`},
+		"DELETE " + testRepoPath + "/refs/branches/file-sync": {status: http.StatusNoContent},
+	})
+	bitbucket := NewBitbucket("https://bitbucket.org", fake.server.URL, "token123")
+
+	closed, err := bitbucket.ClosePullRequest("owner1", "repo1", "file-sync", "main", "no longer needed")
+	assert.NoError(t, err)
+	assert.True(t, closed)
+	assert.Equal(t, []string{
+		"GET " + testRepoPath + "/pullrequests",
+		"POST " + testRepoPath + "/pullrequests/5/comments",
+		"POST " + testRepoPath + "/pullrequests/5/decline",
+		"DELETE " + testRepoPath + "/refs/branches/file-sync",
+	}, fake.requests)
+}
+
+func Test_Bitbucket_ClosePullRequest_None_Open(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testRepoPath + "/pullrequests": {body: `{"values": []}`},
+	})
+	bitbucket := NewBitbucket("https://bitbucket.org", fake.server.URL, "token123")
+
+	closed, err := bitbucket.ClosePullRequest("owner1", "repo1", "file-sync", "main", "no longer needed")
+	assert.NoError(t, err)
+	assert.False(t, closed)
+}
+
+func Test_Bitbucket_ListRepos(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{})
+	// Bitbucket returns the full URL of the next page
+	fake.responses["GET /repositories/owner1?pagelen=100"] = fakeResponse{
+		body: `{"values": [{"slug": "terraform-aws", "full_name": "owner1/terraform-aws"}], "next": "` + fake.server.URL + `/repositories/owner1?pagelen=100&page=2"}`,
+	}
+	fake.responses["GET /repositories/owner1?pagelen=100&page=2"] = fakeResponse{
+		body: `{"values": [{"slug": "website", "full_name": "owner1/website"}]}`,
+	}
+	bitbucket := NewBitbucket("https://bitbucket.org", fake.server.URL, "token123")
+
+	repos, err := bitbucket.ListRepos("owner1", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"owner1/terraform-aws", "owner1/website"}, repos)
+
+	repos, err = bitbucket.ListRepos("owner1", "", "^terraform-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"owner1/terraform-aws"}, repos)
+
+	_, err = bitbucket.ListRepos("owner1", "terraform", "")
+	assert.EqualError(t, err, "filtering repositories by topic is not supported by Bitbucket")
+}
+
+func Test_Bitbucket_Clone(t *testing.T) {
+	bitbucket := NewBitbucket("https://bitbucket.org", "https://api.bitbucket.org/2.0", "token123")
+	assert.Equal(t, "https://bitbucket.org/owner1/repo1.git", bitbucket.CloneURL("owner1/repo1"))
+
+	auth, err := bitbucket.Credentials("owner1", "repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, common.Credentials{Username: "x-token-auth", Password: "token123"}, auth)
+}
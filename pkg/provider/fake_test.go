@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeAPI is a stand-in for the API of a provider. It serves canned responses keyed by method and
+// escaped path, optionally followed by the query, and records every request it receives.
+type fakeAPI struct {
+	server    *httptest.Server
+	mu        sync.Mutex
+	responses map[string]fakeResponse
+	requests  []string
+	// bodies holds the decoded JSON body of each request
+	bodies []map[string]interface{}
+	header http.Header
+}
+
+type fakeResponse struct {
+	status int
+	body   string
+	header map[string]string
+}
+
+func newFakeAPI(t *testing.T, responses map[string]fakeResponse) *fakeAPI {
+	fake := &fakeAPI{responses: responses}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (f *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	route := r.Method + " " + r.URL.EscapedPath()
+	f.requests = append(f.requests, route)
+	f.header = r.Header
+
+	var body map[string]interface{}
+	content, _ := ioutil.ReadAll(r.Body)
+	_ = json.Unmarshal(content, &body)
+	f.bodies = append(f.bodies, body)
+
+	resp, ok := f.responses[route+"?"+r.URL.RawQuery]
+	if !ok {
+		resp, ok = f.responses[route]
+	}
+	if !ok {
+		resp = fakeResponse{status: http.StatusNotFound, body: `{"message": "404 Not Found"}`}
+	}
+	for k, v := range resp.header {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if resp.status == 0 {
+		resp.status = http.StatusOK
+	}
+	w.WriteHeader(resp.status)
+	_, _ = w.Write([]byte(resp.body))
+}
+
+// body returns the JSON body of the request to route
+func (f *fakeAPI) body(route string) map[string]interface{} {
+	for i, r := range f.requests {
+		if r == route {
+			return f.bodies[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/github"
+)
+
+// gitHubUsername is the basic auth user for tokens, as used by actions/checkout
+const gitHubUsername = "x-access-token"
+
+// GitHub opens pull requests on GitHub or GitHub Enterprise Server
+type GitHub struct {
+	serverURL string
+	apiURL    string
+	tokens    github.TokenProvider
+}
+
+func NewGitHub(serverURL, apiURL string, tokens github.TokenProvider) *GitHub {
+	return &GitHub{serverURL: serverURL, apiURL: apiURL, tokens: tokens}
+}
+
+func (g *GitHub) CloneURL(repo string) string {
+	return repoURL(g.serverURL, repo)
+}
+
+func (g *GitHub) Credentials(owner, repo string) (common.Credentials, error) {
+	token, err := g.tokens.Token(owner, repo)
+	if err != nil {
+		return common.Credentials{}, err
+	}
+	return common.Credentials{Username: gitHubUsername, Password: token}, nil
+}
+
+func (g *GitHub) CreatePullRequest(owner, repo string, pr PullRequest) error {
+	client, err := github.NewClient(g.tokens, g.apiURL, owner, repo)
+	if err != nil {
+		return err
+	}
+	return github.CreatePullRequest(client, owner, repo, github.PullRequest(pr))
+}
+
+func (g *GitHub) ClosePullRequest(owner, repo, head, base, comment string) (bool, error) {
+	client, err := github.NewClient(g.tokens, g.apiURL, owner, repo)
+	if err != nil {
+		return false, err
+	}
+	return github.ClosePullRequest(client, owner, repo, head, base, comment)
+}
+
+func (g *GitHub) ListRepos(org, topic, pattern string) ([]string, error) {
+	client, err := github.NewClient(g.tokens, g.apiURL, org, "")
+	if err != nil {
+		return nil, err
+	}
+	return github.ListRepos(client, org, topic, pattern)
+}
@@ -0,0 +1,200 @@
+package provider
+
+import (
+	"fmt"
+	"github.com/champ-oss/file-sync/pkg/common"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// gitLabUsername is the basic auth user for personal, group and project access tokens
+const gitLabUsername = "oauth2"
+
+// GitLab opens merge requests on gitlab.com or a self-managed GitLab server
+type GitLab struct {
+	serverURL string
+	token     string
+	api       *restClient
+}
+
+type gitLabMergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+}
+
+type gitLabProject struct {
+	Path              string   `json:"path"`
+	PathWithNamespace string   `json:"path_with_namespace"`
+	Topics            []string `json:"topics"`
+	Archived          bool     `json:"archived"`
+}
+
+func NewGitLab(serverURL, apiURL, token string) *GitLab {
+	return &GitLab{serverURL: serverURL, token: token, api: newRESTClient(apiURL, func(req *http.Request) {
+		req.Header.Set("PRIVATE-TOKEN", token)
+	})}
+}
+
+func (g *GitLab) CloneURL(repo string) string {
+	return repoURL(g.serverURL, repo) + ".git"
+}
+
+func (g *GitLab) Credentials(_, _ string) (common.Credentials, error) {
+	return common.Credentials{Username: gitLabUsername, Password: g.token}, nil
+}
+
+func (g *GitLab) CreatePullRequest(owner, repo string, pr PullRequest) error {
+	log.Infof("creating merge request for %s -> %s", pr.Head, pr.Base)
+	if len(pr.TeamReviewers) > 0 {
+		log.Warning("team reviewers are not supported by GitLab")
+	}
+	mr, err := g.findMergeRequest(owner, repo, pr.Head, pr.Base)
+	if err != nil {
+		return err
+	}
+
+	title := pr.Title
+	if pr.Draft {
+		title = "Draft: " + title
+	}
+	fields := map[string]interface{}{"title": title, "description": pr.Body}
+	if mr == nil {
+		fields["source_branch"] = pr.Head
+		fields["target_branch"] = pr.Base
+		fields["labels"] = strings.Join(pr.Labels, ",")
+		if fields["assignee_ids"], err = g.userIDs(pr.Assignees); err != nil {
+			return err
+		}
+		if fields["reviewer_ids"], err = g.userIDs(pr.Reviewers); err != nil {
+			return err
+		}
+		mr = &gitLabMergeRequest{}
+		if _, err := g.api.do(http.MethodPost, projectPath(owner, repo)+"/merge_requests", fields, mr); err != nil {
+			return fmt.Errorf("error creating merge request: %w", err)
+		}
+		log.Infof("created merge request: %s", mr.WebURL)
+	} else {
+		// Labels are added to the ones already on the merge request, as on GitHub
+		fields["add_labels"] = strings.Join(pr.Labels, ",")
+		if _, err := g.api.do(http.MethodPut, mergeRequestPath(owner, repo, mr.IID), fields, nil); err != nil {
+			return fmt.Errorf("error updating merge request !%d: %w", mr.IID, err)
+		}
+		log.Infof("updated merge request: %s", mr.WebURL)
+		if pr.Comment != "" {
+			if err := g.comment(owner, repo, mr.IID, pr.Comment); err != nil {
+				return err
+			}
+		}
+	}
+
+	if pr.MergeMethod != "" {
+		return g.autoMerge(owner, repo, mr.IID, pr.MergeMethod)
+	}
+	return nil
+}
+
+func (g *GitLab) ClosePullRequest(owner, repo, head, base, comment string) (bool, error) {
+	mr, err := g.findMergeRequest(owner, repo, head, base)
+	if err != nil || mr == nil {
+		return false, err
+	}
+
+	log.Infof("closing merge request !%d", mr.IID)
+	if err := g.comment(owner, repo, mr.IID, comment); err != nil {
+		return false, err
+	}
+	if _, err := g.api.do(http.MethodPut, mergeRequestPath(owner, repo, mr.IID), map[string]string{"state_event": "close"}, nil); err != nil {
+		return false, fmt.Errorf("error closing merge request !%d: %w", mr.IID, err)
+	}
+	if _, err := g.api.do(http.MethodDelete, projectPath(owner, repo)+"/repository/branches/"+url.PathEscape(head), nil, nil); err != nil {
+		return true, fmt.Errorf("error deleting branch %s: %w", head, err)
+	}
+	return true, nil
+}
+
+func (g *GitLab) ListRepos(org, topic, pattern string) ([]string, error) {
+	log.Infof("listing projects in %s", org)
+	nameRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []string
+	next := "1"
+	for next != "" {
+		var page []gitLabProject
+		resp, err := g.api.do(http.MethodGet, fmt.Sprintf("/groups/%s/projects?archived=false&per_page=100&page=%s", url.PathEscape(org), next), nil, &page)
+		if err != nil {
+			return nil, fmt.Errorf("error listing projects in %s: %w", org, err)
+		}
+		for _, p := range page {
+			if p.Archived || !contains(p.Topics, topic) || !nameRegexp.MatchString(p.Path) {
+				continue
+			}
+			repos = append(repos, p.PathWithNamespace)
+		}
+		next = resp.Header.Get("X-Next-Page")
+	}
+	log.Debugf("found projects: %s", repos)
+	return repos, nil
+}
+
+func (g *GitLab) findMergeRequest(owner, repo, head, base string) (*gitLabMergeRequest, error) {
+	query := url.Values{"state": {"opened"}, "source_branch": {head}, "target_branch": {base}}
+	var mrs []*gitLabMergeRequest
+	if _, err := g.api.do(http.MethodGet, projectPath(owner, repo)+"/merge_requests?"+query.Encode(), nil, &mrs); err != nil {
+		return nil, fmt.Errorf("error finding merge request for %s -> %s: %w", head, base, err)
+	}
+	if len(mrs) == 0 {
+		return nil, nil
+	}
+	return mrs[0], nil
+}
+
+func (g *GitLab) comment(owner, repo string, iid int, body string) error {
+	_, err := g.api.do(http.MethodPost, fmt.Sprintf("%s/notes", mergeRequestPath(owner, repo, iid)), map[string]string{"body": body}, nil)
+	if err != nil {
+		return fmt.Errorf("error commenting on merge request !%d: %w", iid, err)
+	}
+	return nil
+}
+
+// autoMerge sets the merge request to merge when its pipeline succeeds. GitLab merges with the
+// merge method of the project, so only squash is taken from the method.
+func (g *GitLab) autoMerge(owner, repo string, iid int, method string) error {
+	log.Infof("enabling auto-merge for merge request !%d", iid)
+	fields := map[string]interface{}{"merge_when_pipeline_succeeds": true, "squash": method == "squash"}
+	if _, err := g.api.do(http.MethodPut, fmt.Sprintf("%s/merge", mergeRequestPath(owner, repo, iid)), fields, nil); err != nil {
+		return fmt.Errorf("error enabling auto-merge for merge request !%d: %w", iid, err)
+	}
+	return nil
+}
+
+// userIDs looks up the ids of users, which GitLab requires for assignees and reviewers
+func (g *GitLab) userIDs(usernames []string) ([]int, error) {
+	ids := []int{}
+	for _, username := range usernames {
+		var users []struct {
+			ID int `json:"id"`
+		}
+		if _, err := g.api.do(http.MethodGet, "/users?username="+url.QueryEscape(username), nil, &users); err != nil {
+			return nil, fmt.Errorf("error finding GitLab user %s: %w", username, err)
+		}
+		if len(users) == 0 {
+			return nil, fmt.Errorf("GitLab user %s not found", username)
+		}
+		ids = append(ids, users[0].ID)
+	}
+	return ids, nil
+}
+
+func projectPath(owner, repo string) string {
+	return "/projects/" + url.PathEscape(owner+"/"+repo)
+}
+
+func mergeRequestPath(owner, repo string, iid int) string {
+	return fmt.Sprintf("%s/merge_requests/%d", projectPath(owner, repo), iid)
+}
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+const testProjectPath = "/projects/owner1%2Frepo1"
+
+func Test_GitLab_CreatePullRequest(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testProjectPath + "/merge_requests":         {body: `[]`},
+		"GET /users?username=user1":                          {body: `[{"id": 11}]`},
+		"GET /users?username=user2":                          {body: `[{"id": 12}]`},
+		"POST " + testProjectPath + "/merge_requests":        {status: http.StatusCreated, body: `{"iid": 3, "web_url": "https://gitlab.com/owner1/repo1/-/merge_requests/3"}`},
+		"PUT " + testProjectPath + "/merge_requests/3/merge": {body: `{}`},
+	})
+	gitLab := NewGitLab("https://gitlab.com", fake.server.URL, "token123")
+
+	err := gitLab.CreatePullRequest("owner1", "repo1", PullRequest{
+		Title:       "file-sync",
+		Body:        "body",
+		Head:        "file-sync",
+		Base:        "main",
+		Draft:       true,
+		Labels:      []string{"label1", "label2"},
+		Assignees:   []string{"user1"},
+		Reviewers:   []string{"user2"},
+		MergeMethod: "squash",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET " + testProjectPath + "/merge_requests",
+		"GET /users",
+		"GET /users",
+		"POST " + testProjectPath + "/merge_requests",
+		"PUT " + testProjectPath + "/merge_requests/3/merge",
+	}, fake.requests)
+	assert.Equal(t, "token123", fake.header.Get("PRIVATE-TOKEN"))
+	assert.Equal(t, map[string]interface{}{
+		"title":         "Draft: file-sync",
+		"description":   "body",
+		"source_branch": "file-sync",
+		"target_branch": "main",
+		"labels":        "label1,label2",
+		"assignee_ids":  []interface{}{float64(11)},
+		"reviewer_ids":  []interface{}{float64(12)},
+	}, fake.body("POST "+testProjectPath+"/merge_requests"))
+	assert.Equal(t, map[string]interface{}{"merge_when_pipeline_succeeds": true, "squash": true}, fake.body("PUT "+testProjectPath+"/merge_requests/3/merge"))
+}
+
+func Test_GitLab_CreatePullRequest_Already_Open(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testProjectPath + "/merge_requests":          {body: `[{"iid": 3}]`},
+		"PUT " + testProjectPath + "/merge_requests/3":        {body: `{"iid": 3}`},
+		"POST " + testProjectPath + "/merge_requests/3/notes": {status: http.StatusCreated, body: `{}`},
+	})
+	gitLab := NewGitLab("https://gitlab.com", fake.server.URL, "token123")
+
+	err := gitLab.CreatePullRequest("owner1", "repo1", PullRequest{Title: "new title", Head: "file-sync", Base: "main", Labels: []string{"label1"}, Comment: "updated"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET " + testProjectPath + "/merge_requests",
+		"PUT " + testProjectPath + "/merge_requests/3",
+		"POST " + testProjectPath + "/merge_requests/3/notes",
+	}, fake.requests)
+	assert.Equal(t, map[string]interface{}{"title": "new title", "description": "", "add_labels": "label1"}, fake.body("PUT "+testProjectPath+"/merge_requests/3"))
+	assert.Equal(t, map[string]interface{}{"body": "updated"}, fake.body("POST "+testProjectPath+"/merge_requests/3/notes"))
+}
+
+func Test_GitLab_CreatePullRequest_Unknown_User(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testProjectPath + "/merge_requests": {body: `[]`},
+		"GET /users": {body: `[]`},
+	})
+	gitLab := NewGitLab("https://gitlab.com", fake.server.URL, "token123")
+
+	err := gitLab.CreatePullRequest("owner1", "repo1", PullRequest{Head: "file-sync", Base: "main", Assignees: []string{"user1"}})
+	assert.EqualError(t, err, "GitLab user user1 not found")
+}
+
+func Test_GitLab_CreatePullRequest_Error(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testProjectPath + "/merge_requests": {status: http.StatusUnauthorized, body: `{"message": "401 Unauthorized"}`},
+	})
+	gitLab := NewGitLab("https://gitlab.com", fake.server.URL, "bad-token")
+
+	err := gitLab.CreatePullRequest("owner1", "repo1", PullRequest{Head: "file-sync", Base: "main"})
+	assert.Contains(t, err.Error(), "error finding merge request for file-sync -> main")
+	assert.Contains(t, err.Error(), `401 {"message": "401 Unauthorized"}`)
+}
+
+func Test_GitLab_ClosePullRequest(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testProjectPath + "/merge_requests?source_branch=file-sync&state=opened&target_branch=main": {body: `[{"iid": 3}]`},
+		"POST " + testProjectPath + "/merge_requests/3/notes":                                                {status: http.StatusCreated, body: `{}`},
+		"PUT " + testProjectPath + "/merge_requests/3":                                                       {body: `{}`},
+		"DELETE " + testProjectPath + "/repository/branches/file-sync":                                       {status: http.StatusNoContent},
+	})
+	gitLab := NewGitLab("https://gitlab.com", fake.server.URL, "token123")
+
+	closed, err := gitLab.ClosePullRequest("owner1", "repo1", "file-sync", "main", "no longer needed")
+	assert.NoError(t, err)
+	assert.True(t, closed)
+	assert.Equal(t, map[string]interface{}{"state_event": "close"}, fake.body("PUT "+testProjectPath+"/merge_requests/3"))
+	assert.Contains(t, fake.requests, "DELETE "+testProjectPath+"/repository/branches/file-sync")
+}
+
+func Test_GitLab_ClosePullRequest_None_Open(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET " + testProjectPath + "/merge_requests": {body: `[]`},
+	})
+	gitLab := NewGitLab("https://gitlab.com", fake.server.URL, "token123")
+
+	closed, err := gitLab.ClosePullRequest("owner1", "repo1", "file-sync", "main", "no longer needed")
+	assert.NoError(t, err)
+	assert.False(t, closed)
+	assert.Len(t, fake.requests, 1)
+}
+
+func Test_GitLab_ListRepos(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET /groups/owner1/projects?archived=false&per_page=100&page=1": {
+			body:   `[{"path": "terraform-aws", "path_with_namespace": "owner1/terraform-aws", "topics": ["terraform"]}, {"path": "website", "path_with_namespace": "owner1/website"}]`,
+			header: map[string]string{"X-Next-Page": "2"},
+		},
+		"GET /groups/owner1/projects?archived=false&per_page=100&page=2": {
+			body: `[{"path": "terraform-gcp", "path_with_namespace": "owner1/terraform-gcp"}, {"path": "terraform-old", "path_with_namespace": "owner1/terraform-old", "topics": ["terraform"], "archived": true}]`,
+		},
+	})
+	gitLab := NewGitLab("https://gitlab.com", fake.server.URL, "token123")
+
+	repos, err := gitLab.ListRepos("owner1", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"owner1/terraform-aws", "owner1/website", "owner1/terraform-gcp"}, repos)
+
+	repos, err = gitLab.ListRepos("owner1", "terraform", "^terraform-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"owner1/terraform-aws"}, repos)
+}
+
+func Test_GitLab_Clone(t *testing.T) {
+	gitLab := NewGitLab("https://gitlab.example.com/", "https://gitlab.example.com/api/v4", "token123")
+	assert.Equal(t, "https://gitlab.example.com/owner1/repo1.git", gitLab.CloneURL("owner1/repo1"))
+
+	auth, err := gitLab.Credentials("owner1", "repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, common.Credentials{Username: "oauth2", Password: "token123"}, auth)
+}
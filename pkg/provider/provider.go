@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/github"
+	"strings"
+)
+
+// Provider is a git hosting service that repos are cloned from and change requests are opened on
+type Provider interface {
+	// CloneURL returns the HTTPS URL of an owner/repo
+	CloneURL(repo string) string
+	// Credentials returns the basic auth credentials that git clones and pushes the repo with
+	Credentials(owner, repo string) (common.Credentials, error)
+	// CreatePullRequest opens a change request, or updates the one already open for the head branch
+	CreatePullRequest(owner, repo string, pr PullRequest) error
+	// ClosePullRequest closes the change request open for head and deletes the head branch. It
+	// reports whether one was open.
+	ClosePullRequest(owner, repo, head, base, comment string) (bool, error)
+	// ListRepos returns the owner/repo names of the active repos in org that have the topic and
+	// whose name matches pattern
+	ListRepos(org, topic, pattern string) ([]string, error)
+}
+
+// PullRequest holds the options of a change request: a GitHub or Bitbucket pull request or a
+// GitLab merge request. Options a provider does not support are ignored with a warning.
+type PullRequest struct {
+	Title         string
+	Body          string
+	Head          string
+	Base          string
+	Draft         bool
+	Labels        []string
+	Assignees     []string
+	Reviewers     []string
+	TeamReviewers []string
+	// Comment is posted when an existing pull request is updated, unless it is empty
+	Comment string
+	// MergeMethod merges the pull request with the merge, squash or rebase method once it can be
+	// merged, unless it is empty
+	MergeMethod string
+}
+
+// New returns the provider selected by the config. GitHub authenticates as a GitHub App when an
+// app id is configured, and with the token otherwise.
+func New(cfg *config.Config) (Provider, error) {
+	switch cfg.Provider {
+	case config.ProviderGitLab:
+		return NewGitLab(cfg.ServerURL, cfg.APIURL, cfg.Token), nil
+	case config.ProviderBitbucket:
+		return NewBitbucket(cfg.ServerURL, cfg.APIURL, cfg.Token), nil
+	}
+	if cfg.AppID == 0 {
+		return NewGitHub(cfg.ServerURL, cfg.APIURL, github.StaticToken(cfg.Token)), nil
+	}
+	app, err := github.NewApp(int64(cfg.AppID), []byte(cfg.AppPrivateKey), cfg.APIURL)
+	if err != nil {
+		return nil, err
+	}
+	return NewGitHub(cfg.ServerURL, cfg.APIURL, app), nil
+}
+
+// repoURL joins a server URL and an owner/repo
+func repoURL(serverURL, repo string) string {
+	return strings.TrimSuffix(serverURL, "/") + "/" + repo
+}
+
+// contains reports whether values has value, or value is empty
+func contains(values []string, value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
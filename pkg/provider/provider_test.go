@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/github"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_New(t *testing.T) {
+	p, err := New(&config.Config{Provider: config.ProviderGitHub, Token: "token123"})
+	assert.NoError(t, err)
+	assert.IsType(t, &GitHub{}, p)
+
+	p, err = New(&config.Config{Provider: config.ProviderGitLab})
+	assert.NoError(t, err)
+	assert.IsType(t, &GitLab{}, p)
+
+	p, err = New(&config.Config{Provider: config.ProviderBitbucket})
+	assert.NoError(t, err)
+	assert.IsType(t, &Bitbucket{}, p)
+}
+
+func Test_New_App_Invalid_Key(t *testing.T) {
+	_, err := New(&config.Config{Provider: config.ProviderGitHub, AppID: 123, AppPrivateKey: "not a key"})
+	assert.EqualError(t, err, "GitHub App private key is not PEM encoded")
+}
+
+func Test_GitHub(t *testing.T) {
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET /repos/owner1/repo1/pulls":                       {body: `[{"number": 1}]`},
+		"POST /repos/owner1/repo1/issues/1/comments":          {status: http.StatusCreated, body: `{}`},
+		"PATCH /repos/owner1/repo1/pulls/1":                   {body: `{"number": 1}`},
+		"DELETE /repos/owner1/repo1/git/refs/heads/file-sync": {status: http.StatusNoContent},
+	})
+	gitHub := NewGitHub("https://github.example.com/", fake.server.URL, github.StaticToken("token123"))
+	assert.Equal(t, "https://github.example.com/owner1/repo1", gitHub.CloneURL("owner1/repo1"))
+
+	auth, err := gitHub.Credentials("owner1", "repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, "x-access-token", auth.Username)
+	assert.Equal(t, "token123", auth.Password)
+
+	closed, err := gitHub.ClosePullRequest("owner1", "repo1", "file-sync", "main", "no longer needed")
+	assert.NoError(t, err)
+	assert.True(t, closed)
+	assert.Equal(t, "Bearer token123", fake.header.Get("Authorization"))
+}
+
+func Test_restClient_Rate_Limit(t *testing.T) {
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	fake := newFakeAPI(t, map[string]fakeResponse{
+		"GET /limited": {status: http.StatusTooManyRequests, header: map[string]string{"Retry-After": "5"}},
+	})
+	client := newRESTClient(fake.server.URL, func(req *http.Request) {})
+
+	_, err := client.do(http.MethodGet, "/limited", nil, nil)
+	assert.Contains(t, err.Error(), "429")
+	assert.Equal(t, []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second}, waits)
+	assert.Len(t, fake.requests, 4)
+}
+
+func Test_retryWait(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	assert.Equal(t, defaultRetryWait, retryWait(resp))
+	resp.Header.Set("Retry-After", "86400")
+	assert.Equal(t, maxRetryWait, retryWait(resp))
+}
@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxRetries       = 3
+	defaultRetryWait = time.Minute
+	maxRetryWait     = 10 * time.Minute
+)
+
+var sleep = time.Sleep
+
+// restClient calls the JSON API of a provider
+type restClient struct {
+	baseURL string
+	// auth adds the credentials to a request
+	auth   func(req *http.Request)
+	client *http.Client
+}
+
+func newRESTClient(baseURL string, auth func(req *http.Request)) *restClient {
+	return &restClient{baseURL: strings.TrimSuffix(baseURL, "/"), auth: auth, client: http.DefaultClient}
+}
+
+// apiError is returned for responses with an error status
+type apiError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+// do sends body as JSON to path, which is relative to the base URL unless it is a full URL, and
+// decodes the response into result. Rate limited requests are retried after the wait the
+// server asks for.
+func (c *restClient) do(method, path string, body, result interface{}) (*http.Response, error) {
+	url := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		url = c.baseURL + path
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, content, err := c.send(method, url, data)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt <= maxRetries {
+			wait := retryWait(resp)
+			log.Warningf("rate limit reached, retrying in %s", wait)
+			sleep(wait)
+			continue
+		}
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			return resp, &apiError{Method: method, URL: url, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(content))}
+		}
+		if result != nil && len(content) > 0 {
+			if err := json.Unmarshal(content, result); err != nil {
+				return resp, fmt.Errorf("error decoding response of %s %s: %w", method, url, err)
+			}
+		}
+		return resp, nil
+	}
+}
+
+func (c *restClient) send(method, url string, data []byte) (*http.Response, []byte, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.auth(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	return resp, content, err
+}
+
+// retryWait returns the wait given by the Retry-After header of a rate limited response
+func retryWait(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return defaultRetryWait
+	}
+	if wait := time.Duration(seconds) * time.Second; wait < maxRetryWait {
+		return wait
+	}
+	return maxRetryWait
+}
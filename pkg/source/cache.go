@@ -0,0 +1,132 @@
+package source
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/git"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Cache clones each source repo URL and ref once per run. With a directory the clones are kept
+// there between runs and updated with a fetch, otherwise they are temp directories.
+type Cache struct {
+	g      git.GitBackend
+	dir    string
+	clones map[string]*cachedClone
+}
+
+type cachedClone struct {
+	dir string
+	// patterns are the sparse checkout patterns of the clone, which are nil when all files are checked out
+	patterns []string
+}
+
+func NewCache(g git.GitBackend, dir string) *Cache {
+	return &Cache{g: g, dir: dir, clones: map[string]*cachedClone{}}
+}
+
+// Persistent reports whether the clones are kept between runs rather than removed on exit
+func (c *Cache) Persistent() bool {
+	return c.dir != ""
+}
+
+// Clone returns a clone of url at ref, or at the default branch when ref is empty, with at least the
+// files matching the sparse checkout patterns checked out
+func (c *Cache) Clone(url, ref string, auth common.Credentials, patterns []string) (string, error) {
+	key := url + "\n" + ref
+	if clone, ok := c.clones[key]; ok {
+		if coversPatterns(clone.patterns, patterns) {
+			log.Debugf("Using the clone of %s in %s", common.Redact(url), clone.dir)
+			return clone.dir, nil
+		}
+		// The commit that is already checked out is fetched again, so every source of the clone
+		// stays at the same commit
+		sha, err := c.g.RevParse(clone.dir, "HEAD")
+		if err != nil {
+			return "", err
+		}
+		clone.patterns = mergePatterns(clone.patterns, patterns)
+		return clone.dir, c.g.FetchShallow(clone.dir, url, sha, auth, clone.patterns)
+	}
+
+	var dir string
+	var err error
+	if c.Persistent() {
+		dir, err = c.fetchCached(key, url, ref, auth, patterns)
+	} else {
+		dir, _ = ioutil.TempDir("", "repo")
+		if err = c.g.FetchShallow(dir, url, ref, auth, patterns); err != nil {
+			common.RemoveDir(dir)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	c.clones[key] = &cachedClone{dir: dir, patterns: patterns}
+	return dir, nil
+}
+
+// fetchCached updates the clone kept in the cache directory, cloning it again when the update fails
+func (c *Cache) fetchCached(key, url, ref string, auth common.Credentials, patterns []string) (string, error) {
+	dir := filepath.Join(c.dir, fmt.Sprintf("%x", sha256.Sum256([]byte(key))))
+	if _, err := os.Stat(dir); err == nil {
+		log.Infof("Updating the cached clone of %s in %s", common.Redact(url), dir)
+		err := c.g.FetchShallow(dir, url, ref, auth, patterns)
+		if err == nil {
+			return dir, nil
+		}
+		log.Warningf("Could not update the cached clone in %s, cloning again: %s", dir, err)
+		common.RemoveDir(dir)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := c.g.FetchShallow(dir, url, ref, auth, patterns); err != nil {
+		common.RemoveDir(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// coversPatterns reports whether a clone with the sparse checkout patterns have has the files of want
+func coversPatterns(have, want []string) bool {
+	if have == nil {
+		return true
+	}
+	if want == nil {
+		return false
+	}
+	covered := map[string]bool{}
+	for _, p := range have {
+		covered[p] = true
+	}
+	for _, p := range want {
+		if !covered[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergePatterns returns the sparse checkout patterns that check out the files of both a and b
+func mergePatterns(a, b []string) []string {
+	if a == nil || b == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var merged []string
+	for _, p := range append(append([]string{}, a...), b...) {
+		if !seen[p] {
+			seen[p] = true
+			merged = append(merged, p)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,90 @@
+package source
+
+import (
+	"github.com/champ-oss/file-sync/internal/testutil"
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/git/cli"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// filesCommit adds the files a and b to the test repos
+var filesCommit = testutil.Commit{Message: "add files", Files: map[string]string{"a": "a", "b": "b"}}
+
+func Test_Cache_Clone_Reused(t *testing.T) {
+	repo := testutil.NewRepo(t, "main", filesCommit).Dir
+	c := NewCache(cli.Backend{}, "")
+	dir, err := c.Clone("file://"+repo, "main", common.Credentials{}, []string{"/a"})
+	defer common.RemoveDir(dir)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "b"))
+	assert.True(t, os.IsNotExist(err))
+
+	// The same repo and ref share the clone, which checks out the files of both
+	other, err := c.Clone("file://"+repo, "main", common.Credentials{}, []string{"/b"})
+	assert.NoError(t, err)
+	assert.Equal(t, dir, other)
+	for _, f := range []string{"a", "b"} {
+		_, err = os.Stat(filepath.Join(dir, f))
+		assert.NoError(t, err)
+	}
+
+	other, err = c.Clone("file://"+repo, "", common.Credentials{}, nil)
+	defer common.RemoveDir(other)
+	assert.NoError(t, err)
+	assert.NotEqual(t, dir, other)
+}
+
+func Test_Cache_Clone_Persistent(t *testing.T) {
+	fixture := testutil.NewRepo(t, "main", filesCommit)
+	repo := fixture.Dir
+	cacheDir := t.TempDir()
+	dir, err := NewCache(cli.Backend{}, cacheDir).Clone("file://"+repo, "main", common.Credentials{}, []string{"/a"})
+	assert.NoError(t, err)
+	assert.Equal(t, cacheDir, filepath.Dir(dir))
+
+	// A later run fetches the new commit into the same clone
+	fixture.Commit(testutil.Commit{Message: "update a", Files: map[string]string{"a": "updated a"}})
+	s, err := Clone(NewCache(cli.Backend{}, cacheDir), "files.git", "file://"+repo, "main", common.Credentials{}, common.ParseFiles([]string{"a"}))
+	assert.NoError(t, err)
+	assert.Equal(t, dir, s.Dir)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "updated a", string(content))
+
+	// Cleanup keeps the cached clone
+	s.Cleanup()
+	_, err = os.Stat(dir)
+	assert.NoError(t, err)
+
+	// A broken clone is cloned again
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, ".git", "objects")))
+	again, err := NewCache(cli.Backend{}, cacheDir).Clone("file://"+repo, "main", common.Credentials{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, dir, again)
+	_, err = os.Stat(filepath.Join(dir, "b"))
+	assert.NoError(t, err)
+}
+
+func Test_Cache_Clone_Error(t *testing.T) {
+	repo := testutil.NewRepo(t, "main", filesCommit).Dir
+	cacheDir := t.TempDir()
+	_, err := NewCache(cli.Backend{}, cacheDir).Clone("file://"+repo, "not-a-ref", common.Credentials{}, nil)
+	assert.Error(t, err)
+
+	entries, err := ioutil.ReadDir(cacheDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_mergePatterns(t *testing.T) {
+	assert.Equal(t, []string{"/a", "/b"}, mergePatterns([]string{"/b"}, []string{"/a", "/b"}))
+	assert.Nil(t, mergePatterns([]string{"/a"}, nil))
+	assert.True(t, coversPatterns([]string{"/a", "/b"}, []string{"/b"}))
+	assert.True(t, coversPatterns(nil, []string{"/b"}))
+	assert.False(t, coversPatterns([]string{"/a"}, nil))
+	assert.False(t, coversPatterns([]string{"/a"}, []string{"/b"}))
+}
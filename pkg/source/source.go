@@ -0,0 +1,145 @@
+package source
+
+import (
+	"fmt"
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/git"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+type Source struct {
+	Repo  string
+	Ref   string
+	SHA   string
+	Dir   string
+	Files []common.File
+	// cloned is set when Dir is a temporary clone that is removed by Cleanup
+	cloned bool
+}
+
+// Clone clones the source repo from url at ref, or at the default branch when ref is empty, through
+// the cache. Only the latest commit and the files to sync are fetched.
+func Clone(c *Cache, repo, url, ref string, auth common.Credentials, files []common.File) (*Source, error) {
+	// Relative local paths are made absolute, since git fetches them from within the clone
+	if strings.HasPrefix(url, ".") {
+		abs, err := filepath.Abs(url)
+		if err != nil {
+			return nil, err
+		}
+		url = abs
+	}
+	dir, err := c.Clone(url, ref, auth, common.SparseCheckoutPatterns(files))
+	if err != nil {
+		return nil, err
+	}
+	source := &Source{Repo: repo, Ref: ref, Dir: dir, Files: files, cloned: !c.Persistent()}
+
+	source.SHA, err = c.g.RevParse(dir, "HEAD")
+	if err != nil {
+		source.Cleanup()
+		return nil, err
+	}
+	log.Infof("source commit: %s", source)
+	return source, nil
+}
+
+// Open uses an existing local repository as a source, such as the workspace when syncing from the template repo
+func Open(g git.GitBackend, name, dir string, files []common.File) (*Source, error) {
+	sha, err := g.RevParse(dir, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	source := &Source{Repo: name, Dir: dir, SHA: sha, Files: files}
+	log.Infof("source commit: %s", source)
+	return source, nil
+}
+
+// IsRemote reports whether a source repo is a git URL or local path, rather than an owner/repo on
+// the provider
+func IsRemote(repo string) bool {
+	if strings.Contains(repo, "://") || strings.HasPrefix(repo, "/") || strings.HasPrefix(repo, ".") {
+		return true
+	}
+	// scp-like syntax such as git@example.com:owner/repo.git
+	colon := strings.Index(repo, ":")
+	return colon > 0 && !strings.Contains(repo[:colon], "/")
+}
+
+// userNames are the user names providers expect in front of a token, which are not secrets
+var userNames = map[string]bool{"git": true, "oauth2": true, "x-access-token": true, "x-token-auth": true}
+
+// Name returns how a remote source is shown in logs, commit messages and pull requests, without
+// the credentials of an HTTP URL. The credentials are registered as secrets.
+func Name(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || u.User == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return repo
+	}
+	if password, ok := u.User.Password(); ok {
+		common.AddSecret(password)
+	} else if !userNames[u.User.Username()] {
+		// A token can be the only user info, as in https://<token>@github.com/owner/repo
+		common.AddSecret(u.User.Username())
+	}
+	u.User = nil
+	return u.String()
+}
+
+func (s *Source) String() string {
+	return fmt.Sprintf("%s@%s", s.Repo, s.SHA)
+}
+
+func (s *Source) Cleanup() {
+	if s.cloned {
+		common.RemoveDir(s.Dir)
+	}
+}
+
+// Expand returns a copy of the sources with their files expanded against destDir, and an error
+// if more than one source writes to the same destination path
+func Expand(sources []*Source, destDir string) ([]*Source, error) {
+	var expanded []*Source
+	for _, s := range sources {
+		files, err := common.ExpandFiles(s.Files, s.Dir, destDir)
+		if err != nil {
+			return nil, err
+		}
+		e := *s
+		e.Files = files
+		e.cloned = false
+		expanded = append(expanded, &e)
+	}
+	if err := CheckConflicts(expanded); err != nil {
+		return nil, err
+	}
+	return expanded, nil
+}
+
+func (s *Source) CopyFiles(destDir string) error {
+	return common.CopySourceFiles(s.Files, s.Dir, destDir)
+}
+
+// CheckConflicts returns an error when more than one source writes to the same destination path
+func CheckConflicts(sources []*Source) error {
+	claimed := map[string]*Source{}
+	for _, s := range sources {
+		for _, f := range s.Files {
+			if other, ok := claimed[f.Destination]; ok && other != s {
+				return fmt.Errorf("%s is claimed by both %s and %s", f.Destination, other.Repo, s.Repo)
+			}
+			claimed[f.Destination] = s
+		}
+	}
+	return nil
+}
+
+func DestinationPaths(sources []*Source) []string {
+	var paths []string
+	for _, s := range sources {
+		paths = append(paths, common.DestinationPaths(s.Files)...)
+	}
+	return paths
+}
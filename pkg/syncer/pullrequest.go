@@ -0,0 +1,95 @@
+package syncer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/git"
+	"github.com/champ-oss/file-sync/pkg/provider"
+	"github.com/champ-oss/file-sync/pkg/source"
+	"strings"
+	"text/template"
+)
+
+// pullRequestData is available to the pull request title and body templates
+type pullRequestData struct {
+	// Repo is the target repo in owner/repo format
+	Repo    string
+	Branch  string
+	Base    string
+	Sources []*source.Source
+	// Source is the first source, for configs that sync from a single repo
+	Source *source.Source
+	// Files are the files changed on the pull request branch
+	Files    []string
+	DiffStat string
+	// Changes holds the porcelain git status of each file changed by this run
+	Changes []string
+}
+
+// pullRequest renders the pull request for the changes on the pull request branch checked out in dir.
+// The comment is only rendered when this run changed files.
+func pullRequest(cfg *config.Config, g git.GitBackend, sources []*source.Source, changes []string, owner, repo, dir string) (provider.PullRequest, error) {
+	base := fmt.Sprintf("origin/%s", cfg.TargetBranch)
+	files, err := g.ChangedFiles(dir, base)
+	if err != nil {
+		return provider.PullRequest{}, err
+	}
+	diffStat, err := g.DiffStat(dir, base)
+	if err != nil {
+		return provider.PullRequest{}, err
+	}
+
+	data := pullRequestData{
+		Repo:     fmt.Sprintf("%s/%s", owner, repo),
+		Branch:   cfg.PullRequestBranch,
+		Base:     cfg.TargetBranch,
+		Sources:  sources,
+		Files:    files,
+		DiffStat: diffStat,
+		Changes:  changes,
+	}
+	if len(sources) > 0 {
+		data.Source = sources[0]
+	}
+
+	title, err := renderTemplate("title", cfg.PullRequestTitle, data)
+	if err != nil {
+		return provider.PullRequest{}, err
+	}
+	body, err := renderTemplate("body", cfg.PullRequestBody, data)
+	if err != nil {
+		return provider.PullRequest{}, err
+	}
+	var comment string
+	if cfg.PullRequestComment != "" && len(changes) > 0 {
+		if comment, err = renderTemplate("comment", cfg.PullRequestComment, data); err != nil {
+			return provider.PullRequest{}, err
+		}
+	}
+	return provider.PullRequest{
+		Title:         strings.TrimSpace(title),
+		Body:          body,
+		Head:          cfg.PullRequestBranch,
+		Base:          cfg.TargetBranch,
+		Draft:         cfg.Draft,
+		Labels:        cfg.Labels,
+		Assignees:     cfg.Assignees,
+		Reviewers:     cfg.Reviewers,
+		TeamReviewers: cfg.TeamReviewers,
+		Comment:       comment,
+		MergeMethod:   cfg.AutoMerge,
+	}, nil
+}
+
+func renderTemplate(name, text string, data pullRequestData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing pull request %s template: %w", name, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("error rendering pull request %s template: %w", name, err)
+	}
+	return out.String(), nil
+}
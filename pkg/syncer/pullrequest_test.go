@@ -0,0 +1,53 @@
+package syncer
+
+import (
+	"github.com/champ-oss/file-sync/pkg/git/cli"
+	"github.com/champ-oss/file-sync/pkg/source"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_pullRequest(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	sources := []*source.Source{testSource(t)}
+	changes, err := syncFiles(testConfig(), cli.Backend{}, sources, repoDir)
+	assert.NoError(t, err)
+
+	cfg := testConfig()
+	cfg.PullRequestTitle = "Sync {{ .Source.Repo }}@{{ .Source.SHA }}\n"
+	cfg.PullRequestBody = "{{ .Repo }}: {{ range .Files }}{{ . }} {{ end }}\n{{ .DiffStat }}"
+	cfg.PullRequestComment = "Updated {{ range .Changes }}{{ . }}{{ end }}"
+	cfg.Labels = []string{"file-sync"}
+	cfg.Draft = true
+	cfg.AutoMerge = "squash"
+
+	pr, err := pullRequest(cfg, cli.Backend{}, sources, changes, "owner1", "repo1", repoDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sync owner1/source1@abc123", pr.Title)
+	assert.Contains(t, pr.Body, "owner1/repo1: LICENSE \n")
+	assert.Contains(t, pr.Body, "1 file changed, 1 insertion(+)")
+	assert.Equal(t, "file-sync", pr.Head)
+	assert.Equal(t, "main", pr.Base)
+	assert.Equal(t, []string{"file-sync"}, pr.Labels)
+	assert.True(t, pr.Draft)
+	assert.Equal(t, "squash", pr.MergeMethod)
+	assert.Equal(t, "Updated ?? LICENSE", pr.Comment)
+
+	pr, err = pullRequest(cfg, cli.Backend{}, sources, nil, "owner1", "repo1", repoDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "", pr.Comment)
+}
+
+func Test_pullRequest_Invalid_Template(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	cfg := testConfig()
+	cfg.PullRequestTitle = "{{ .Foo }}"
+
+	_, err := pullRequest(cfg, cli.Backend{}, nil, nil, "owner1", "repo1", repoDir)
+	assert.Contains(t, err.Error(), "error rendering pull request title template")
+}
+
+func Test_pullRequest_Error(t *testing.T) {
+	_, err := pullRequest(testConfig(), cli.Backend{}, nil, nil, "owner1", "repo1", t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,415 @@
+package syncer
+
+import (
+	"fmt"
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/git"
+	"github.com/champ-oss/file-sync/pkg/provider"
+	"github.com/champ-oss/file-sync/pkg/source"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const closeComment = "The synced files in %s already match the source repositories, so this pull request is no longer needed."
+
+const (
+	StatusUpdated  = "updated"
+	StatusChanged  = "has changes"
+	StatusUpToDate = "up to date"
+	StatusClosed   = "closed obsolete pull request"
+	StatusFailed   = "failed"
+)
+
+type Result struct {
+	Repo   string
+	Status string
+	Err    error
+	// Changes holds the porcelain git status of each changed file
+	Changes []string
+	// Diffs holds the unified diff of each changed file
+	Diffs []string
+}
+
+// TargetFunc processes a local clone of a target repo
+type TargetFunc func(owner, repo, dir string) Result
+
+// PrepareSources clones each configured source repo. In fan-out mode a source without a repo
+// uses the workspace, which is the template repo the action runs in.
+func PrepareSources(cfg *config.Config, p provider.Provider, g git.GitBackend) ([]*source.Source, error) {
+	sshAuth, cleanup, err := sshCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	cache := source.NewCache(g, cfg.CacheDir)
+	var sources []*source.Source
+	for _, s := range cfg.GetSources() {
+		var src *source.Source
+		var err error
+		if s.Repo == "" {
+			src, err = source.Open(g, fmt.Sprintf("%s/%s", cfg.Owner, cfg.Repo), cfg.Workspace, cfg.SyncFiles(s))
+		} else {
+			src, err = cloneSource(cfg, p, cache, sshAuth, s)
+		}
+		if err != nil {
+			CleanupSources(sources)
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// cloneSource clones a git URL or local path as is, authenticating SSH remotes with the SSH key,
+// and an owner/repo from the provider. Sources with the same repo and ref share a clone.
+func cloneSource(cfg *config.Config, p provider.Provider, cache *source.Cache, sshAuth common.Credentials, s config.Source) (*source.Source, error) {
+	if source.IsRemote(s.Repo) {
+		return source.Clone(cache, source.Name(s.Repo), s.Repo, s.Ref, sshAuth, cfg.SyncFiles(s))
+	}
+	auth, err := p.Credentials(common.SplitRepo(s.Repo))
+	if err != nil {
+		return nil, err
+	}
+	return source.Clone(cache, s.Repo, p.CloneURL(s.Repo), s.Ref, auth, cfg.SyncFiles(s))
+}
+
+// sshCredentials writes the configured SSH key and known hosts to a temp directory, which is
+// removed by cleanup once the sources are cloned
+func sshCredentials(cfg *config.Config) (auth common.Credentials, cleanup func(), err error) {
+	if cfg.SSHKey == "" {
+		return auth, func() {}, nil
+	}
+	dir, err := ioutil.TempDir("", "ssh")
+	if err != nil {
+		return auth, nil, err
+	}
+	cleanup = func() { common.RemoveDir(dir) }
+
+	// ssh refuses keys that other users can read and keys without a trailing newline
+	auth.SSHKeyFile = filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(auth.SSHKeyFile, []byte(strings.TrimSpace(cfg.SSHKey)+"\n"), 0600); err != nil {
+		cleanup()
+		return auth, nil, err
+	}
+	if cfg.SSHKnownHosts != "" {
+		auth.KnownHostsFile = filepath.Join(dir, "known_hosts")
+		if err := ioutil.WriteFile(auth.KnownHostsFile, []byte(strings.TrimSpace(cfg.SSHKnownHosts)+"\n"), 0644); err != nil {
+			cleanup()
+			return auth, nil, err
+		}
+	}
+	return auth, cleanup, nil
+}
+
+func CleanupSources(sources []*source.Source) {
+	for _, s := range sources {
+		s.Cleanup()
+	}
+}
+
+// Targets returns the configured target repos along with the repos found in the target org
+func Targets(cfg *config.Config, p provider.Provider) ([]string, error) {
+	targets := cfg.Targets
+	if cfg.TargetOrg != "" {
+		repos, err := p.ListRepos(cfg.TargetOrg, cfg.TargetTopic, cfg.TargetPattern)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, repos...)
+	}
+
+	self := fmt.Sprintf("%s/%s", cfg.Owner, cfg.Repo)
+	var unique []string
+	seen := map[string]bool{}
+	for _, t := range targets {
+		if seen[strings.ToLower(t)] || strings.EqualFold(t, self) {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		unique = append(unique, t)
+	}
+	log.Infof("target repositories: %s", unique)
+	return unique, nil
+}
+
+// FanOut clones each target repo into its own temp directory and runs fn on it, processing up to
+// cfg.Concurrency targets at a time. Results are returned in the order of targets.
+func FanOut(cfg *config.Config, p provider.Provider, g git.GitBackend, targets []string, fn TargetFunc) []Result {
+	results := make([]Result, len(targets))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := cfg.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = processTarget(p, g, targets[i], fn)
+			}
+		}()
+	}
+
+	for i := range targets {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+func processTarget(p provider.Provider, g git.GitBackend, target string, fn TargetFunc) Result {
+	log.Infof("Processing target repository: %s", target)
+	owner, repo := common.SplitRepo(target)
+	// The credentials are fetched for each target so that GitHub App installation tokens are
+	// refreshed during long runs
+	auth, err := p.Credentials(owner, repo)
+	if err != nil {
+		return Result{Repo: target, Status: StatusFailed, Err: err}
+	}
+
+	dir, err := g.Clone(p.CloneURL(target), auth)
+	defer common.RemoveDir(dir)
+	if err != nil {
+		return Result{Repo: target, Status: StatusFailed, Err: err}
+	}
+	return fn(owner, repo, dir)
+}
+
+// Sync copies the source files into a local clone of the target repo, pushes any changes to the
+// pull request branch and opens a pull request
+func Sync(cfg *config.Config, p provider.Provider, g git.GitBackend, sources []*source.Source, owner, repo, dir string) Result {
+	result := Result{Repo: fmt.Sprintf("%s/%s", owner, repo), Status: StatusUpToDate}
+	changes, err := syncFiles(cfg, g, sources, dir)
+	if err != nil {
+		return failed(result, err)
+	}
+
+	if len(changes) == 0 {
+		// A pull request is not needed when the target branch already has the synced files, such
+		// as when the target repo was updated by hand, so any open one is closed
+		obsolete, err := inSync(cfg, g, sources, dir)
+		if err != nil {
+			return failed(result, err)
+		}
+		if obsolete {
+			comment := fmt.Sprintf(closeComment, cfg.TargetBranch)
+			closed, err := p.ClosePullRequest(owner, repo, cfg.PullRequestBranch, cfg.TargetBranch, comment)
+			if err != nil {
+				return failed(result, err)
+			}
+			if closed {
+				result.Status = StatusClosed
+			}
+			return result
+		}
+	} else {
+		result.Status = StatusUpdated
+		result.Changes = changes
+	}
+
+	pr, err := pullRequest(cfg, g, sources, changes, owner, repo, dir)
+	if err != nil {
+		return failed(result, err)
+	}
+	if err := p.CreatePullRequest(owner, repo, pr); err != nil {
+		return failed(result, err)
+	}
+	return result
+}
+
+func failed(result Result, err error) Result {
+	result.Status = StatusFailed
+	result.Err = err
+	return result
+}
+
+// syncFiles copies the source files onto the pull request branch and pushes a commit when any of
+// them changed. It returns the porcelain git status of each changed file.
+func syncFiles(cfg *config.Config, g git.GitBackend, sources []*source.Source, dir string) ([]string, error) {
+	if err := g.SetAuthor(dir, cfg.User, cfg.Email); err != nil {
+		return nil, err
+	}
+	if err := g.Fetch(dir); err != nil {
+		return nil, err
+	}
+	if err := g.Branch(dir, cfg.PullRequestBranch); err != nil {
+		return nil, err
+	}
+	if err := g.Checkout(dir, cfg.PullRequestBranch); err != nil {
+		return nil, err
+	}
+	if err := g.Reset(dir, cfg.PullRequestBranch); err != nil {
+		return nil, err
+	}
+
+	destinations, err := copyFiles(sources, dir)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := status(g, dir, destinations)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		log.Info("all files are up to date")
+		return nil, nil
+	}
+
+	for _, f := range destinations {
+		if err := stage(g, dir, f); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.Commit(dir, commitMessage(cfg.CommitMessage, sources)); err != nil {
+		return nil, err
+	}
+	if err := g.Push(dir, cfg.PullRequestBranch); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// inSync reports whether none of the synced files on the pull request branch differ from the target branch
+func inSync(cfg *config.Config, g git.GitBackend, sources []*source.Source, dir string) (bool, error) {
+	expanded, err := source.Expand(sources, dir)
+	if err != nil {
+		return false, err
+	}
+	differs, err := g.Differs(dir, fmt.Sprintf("origin/%s", cfg.TargetBranch), source.DestinationPaths(expanded))
+	return !differs, err
+}
+
+// Diff copies the source files into dir and reports the files that would change, without
+// switching branches, committing or opening a pull request
+func Diff(g git.GitBackend, sources []*source.Source, owner, repo, dir string) Result {
+	result := Result{Repo: fmt.Sprintf("%s/%s", owner, repo), Status: StatusUpToDate}
+	destinations, err := copyFiles(sources, dir)
+	if err != nil {
+		return failed(result, err)
+	}
+
+	for _, f := range destinations {
+		status, err := g.Status(dir, f)
+		if err != nil {
+			return failed(result, err)
+		}
+		if status == "" {
+			continue
+		}
+		result.Changes = append(result.Changes, strings.TrimRight(status, "\n"))
+
+		diff, err := g.Diff(dir, f)
+		if err != nil {
+			return failed(result, err)
+		}
+		result.Diffs = append(result.Diffs, diff)
+	}
+	if len(result.Changes) > 0 {
+		result.Status = StatusChanged
+	}
+	return result
+}
+
+// status returns the porcelain git status of each changed file
+func status(g git.GitBackend, dir string, files []string) ([]string, error) {
+	var changes []string
+	for _, f := range files {
+		s, err := g.Status(dir, f)
+		if err != nil {
+			return nil, err
+		}
+		if s != "" {
+			changes = append(changes, strings.TrimRight(s, "\n"))
+		}
+	}
+	return changes, nil
+}
+
+// copyFiles copies the files of each source into dir and returns the destination paths
+func copyFiles(sources []*source.Source, dir string) ([]string, error) {
+	expanded, err := source.Expand(sources, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range expanded {
+		if err := s.CopyFiles(dir); err != nil {
+			return nil, err
+		}
+	}
+	return source.DestinationPaths(expanded), nil
+}
+
+// stage adds a file to the index, or stages its removal when it no longer exists
+func stage(g git.GitBackend, dir, file string) error {
+	if _, err := os.Lstat(filepath.Join(dir, file)); os.IsNotExist(err) {
+		return g.Remove(dir, file)
+	}
+	return g.Add(dir, file)
+}
+
+// FanOutError is returned when one or more target repos failed to sync
+type FanOutError struct {
+	Failed []Result
+	Total  int
+}
+
+func (e *FanOutError) Error() string {
+	var failures []string
+	for _, r := range e.Failed {
+		failures = append(failures, fmt.Sprintf("%s: %s", r.Repo, r.Err))
+	}
+	return fmt.Sprintf("%d of %d target repositories failed: %s", len(e.Failed), e.Total, strings.Join(failures, "; "))
+}
+
+// Err aggregates the errors of all failed results, or returns nil when every target succeeded
+func Err(results []Result) error {
+	var failed []Result
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &FanOutError{Failed: failed, Total: len(results)}
+}
+
+// LogSummary logs the result and changed files of each target repo
+func LogSummary(results []Result) {
+	failed := 0
+	log.Info("Summary:")
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			log.Errorf("%s: %s: %s", r.Repo, r.Status, r.Err)
+			continue
+		}
+		log.Infof("%s: %s", r.Repo, r.Status)
+		for _, c := range r.Changes {
+			log.Infof("  %s", c)
+		}
+		for _, d := range r.Diffs {
+			fmt.Print(d)
+		}
+	}
+	log.Infof("%d repositories synced, %d failed", len(results)-failed, failed)
+}
+
+func commitMessage(message string, sources []*source.Source) string {
+	message += "\n"
+	for _, s := range sources {
+		message += fmt.Sprintf("\nSource: %s", s)
+	}
+	return message
+}
@@ -0,0 +1,392 @@
+package syncer
+
+import (
+	"fmt"
+	"github.com/champ-oss/file-sync/internal/testutil"
+	"github.com/champ-oss/file-sync/pkg/common"
+	"github.com/champ-oss/file-sync/pkg/config"
+	"github.com/champ-oss/file-sync/pkg/git/cli"
+	"github.com/champ-oss/file-sync/pkg/git/native"
+	"github.com/champ-oss/file-sync/pkg/github"
+	"github.com/champ-oss/file-sync/pkg/provider"
+	"github.com/champ-oss/file-sync/pkg/source"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTargetRepo creates a bare repository with an initial commit on main and returns a clone of it
+func createTargetRepo(t *testing.T) string {
+	remoteDir := testutil.NewRepo(t, "main", testutil.Commit{Message: "initial commit"}).Dir
+	repoDir, err := cli.CloneWithAuth(remoteDir, common.Credentials{})
+	assert.NoError(t, err)
+	t.Cleanup(func() { common.RemoveDir(repoDir) })
+	return repoDir
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		PullRequestBranch: "file-sync",
+		TargetBranch:      "main",
+		User:              "testuser",
+		Email:             "testuser@example.com",
+		CommitMessage:     "test commit",
+	}
+}
+
+func testSource(t *testing.T) *source.Source {
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "LICENSE"), []byte("test"), 0644))
+	return &source.Source{Repo: "owner1/source1", SHA: "abc123", Dir: dir, Files: []common.File{{Source: "LICENSE", Destination: "LICENSE"}}}
+}
+
+func Test_syncFiles_Modified(t *testing.T) {
+	repoDir := createTargetRepo(t)
+
+	changes, err := syncFiles(testConfig(), cli.Backend{}, []*source.Source{testSource(t)}, repoDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"?? LICENSE"}, changes)
+
+	output, err := common.RunCommand(repoDir, "git", "log", "-1", "--format=%B", "origin/file-sync")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "test commit\n\nSource: owner1/source1@abc123")
+}
+
+func Test_syncFiles_Up_To_Date(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	sources := []*source.Source{testSource(t)}
+
+	_, err := syncFiles(testConfig(), cli.Backend{}, sources, repoDir)
+	assert.NoError(t, err)
+
+	changes, err := syncFiles(testConfig(), cli.Backend{}, sources, repoDir)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func Test_syncFiles_Removed(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	s := testSource(t)
+	_, err := syncFiles(testConfig(), cli.Backend{}, []*source.Source{s}, repoDir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.Remove(filepath.Join(s.Dir, "LICENSE")))
+	s.Files[0].DeleteMissing = true
+	changes, err := syncFiles(testConfig(), cli.Backend{}, []*source.Source{s}, repoDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{" D LICENSE"}, changes)
+	_, err = os.Stat(filepath.Join(repoDir, "LICENSE"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_syncFiles_Native(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	s := testSource(t)
+	g := native.NewBackend()
+
+	changes, err := syncFiles(testConfig(), g, []*source.Source{s}, repoDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"?? LICENSE"}, changes)
+	output, err := common.RunCommand(repoDir, "git", "log", "-1", "--format=%an %B", "origin/file-sync")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "testuser test commit\n\nSource: owner1/source1@abc123")
+
+	changes, err = syncFiles(testConfig(), g, []*source.Source{s}, repoDir)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	assert.NoError(t, os.Remove(filepath.Join(s.Dir, "LICENSE")))
+	s.Files[0].DeleteMissing = true
+	changes, err = syncFiles(testConfig(), g, []*source.Source{s}, repoDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{" D LICENSE"}, changes)
+}
+
+func Test_syncFiles_Error(t *testing.T) {
+	_, err := syncFiles(testConfig(), cli.Backend{}, []*source.Source{testSource(t)}, t.TempDir())
+	assert.Error(t, err)
+}
+
+// testGitHub returns a provider for a fake GitHub API with one open pull request, recording the requests it receives
+func testGitHub(t *testing.T) (provider.Provider, *[]string) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner1/repo1/pulls":
+			fmt.Fprint(w, `[{"number": 1}]`)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			fmt.Fprint(w, `{"number": 1}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return provider.NewGitHub("", server.URL, github.StaticToken("token123")), &requests
+}
+
+func Test_Sync(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	client, requests := testGitHub(t)
+
+	result := Sync(testConfig(), client, cli.Backend{}, []*source.Source{testSource(t)}, "owner1", "repo1", repoDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, StatusUpdated, result.Status)
+	assert.Equal(t, []string{"POST /repos/owner1/repo1/pulls"}, *requests)
+}
+
+func Test_Sync_Close_Obsolete(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	client, requests := testGitHub(t)
+	sources := []*source.Source{testSource(t)}
+	_, err := syncFiles(testConfig(), cli.Backend{}, sources, repoDir)
+	assert.NoError(t, err)
+
+	// Bring the target branch in line with the source by hand
+	for _, args := range [][]string{
+		{"checkout", "main"},
+		{"checkout", "file-sync", "--", "LICENSE"},
+		{"commit", "-m", "add license by hand"},
+		{"push", "origin", "main"},
+	} {
+		_, err = common.RunCommand(repoDir, "git", args...)
+		assert.NoError(t, err)
+	}
+
+	result := Sync(testConfig(), client, cli.Backend{}, sources, "owner1", "repo1", repoDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, StatusClosed, result.Status)
+	assert.Equal(t, []string{
+		"GET /repos/owner1/repo1/pulls",
+		"POST /repos/owner1/repo1/issues/1/comments",
+		"PATCH /repos/owner1/repo1/pulls/1",
+		"DELETE /repos/owner1/repo1/git/refs/heads/file-sync",
+	}, *requests)
+}
+
+func Test_inSync(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	sources := []*source.Source{testSource(t)}
+	_, err := syncFiles(testConfig(), cli.Backend{}, sources, repoDir)
+	assert.NoError(t, err)
+
+	synced, err := inSync(testConfig(), cli.Backend{}, sources, repoDir)
+	assert.NoError(t, err)
+	assert.False(t, synced)
+
+	synced, err = inSync(testConfig(), cli.Backend{}, nil, repoDir)
+	assert.NoError(t, err)
+	assert.True(t, synced)
+}
+
+func Test_processTarget_Error(t *testing.T) {
+	result := processTarget(provider.NewGitHub(t.TempDir(), "", github.StaticToken("")), cli.Backend{}, "localhost/not-a-repo", func(owner, repo, dir string) Result {
+		panic("should not be called")
+	})
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Error(t, result.Err)
+}
+
+func Test_Diff_Changed(t *testing.T) {
+	repoDir := createTargetRepo(t)
+
+	result := Diff(cli.Backend{}, []*source.Source{testSource(t)}, "owner1", "repo1", repoDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "owner1/repo1", result.Repo)
+	assert.Equal(t, StatusChanged, result.Status)
+	assert.Equal(t, []string{"?? LICENSE"}, result.Changes)
+	assert.Len(t, result.Diffs, 1)
+	assert.Contains(t, result.Diffs[0], "new file mode 100644")
+	assert.Contains(t, result.Diffs[0], "+test")
+
+	output, err := common.RunCommand(repoDir, "git", "log", "--oneline")
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "test commit")
+}
+
+func Test_Diff_Modified_And_Removed(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	s := testSource(t)
+	_, err := syncFiles(testConfig(), cli.Backend{}, []*source.Source{s}, repoDir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(s.Dir, "LICENSE"), []byte("updated"), 0644))
+	result := Diff(cli.Backend{}, []*source.Source{s}, "owner1", "repo1", repoDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []string{" M LICENSE"}, result.Changes)
+	assert.Contains(t, result.Diffs[0], "+updated")
+
+	assert.NoError(t, os.Remove(filepath.Join(s.Dir, "LICENSE")))
+	s.Files[0].DeleteMissing = true
+	result = Diff(cli.Backend{}, []*source.Source{s}, "owner1", "repo1", repoDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []string{" D LICENSE"}, result.Changes)
+	assert.Contains(t, result.Diffs[0], "deleted file mode 100644")
+
+	output, err := common.RunCommand(repoDir, "git", "diff", "--cached", "--name-only")
+	assert.NoError(t, err)
+	assert.Equal(t, "", output)
+}
+
+func Test_Diff_Up_To_Date(t *testing.T) {
+	repoDir := createTargetRepo(t)
+
+	result := Diff(cli.Backend{}, nil, "owner1", "repo1", repoDir)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, StatusUpToDate, result.Status)
+}
+
+func Test_Diff_Error(t *testing.T) {
+	s := testSource(t)
+	s.Files = []common.File{{Source: "missing", Destination: "missing"}}
+
+	result := Diff(cli.Backend{}, []*source.Source{s}, "owner1", "repo1", t.TempDir())
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Error(t, result.Err)
+}
+
+func Test_Diff_Status_Error(t *testing.T) {
+	result := Diff(cli.Backend{}, []*source.Source{testSource(t)}, "owner1", "repo1", t.TempDir())
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Empty(t, result.Changes)
+	assert.Contains(t, result.Err.Error(), "git status failed")
+}
+
+func Test_Targets(t *testing.T) {
+	cfg := &config.Config{Owner: "owner1", Repo: "template", Targets: []string{"owner1/repo1", "owner1/Repo1", "owner1/template", "owner1/repo2"}}
+	targets, err := Targets(cfg, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"owner1/repo1", "owner1/repo2"}, targets)
+}
+
+func Test_PrepareSources_Workspace(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	cfg := &config.Config{Owner: "owner1", Repo: "template", Workspace: repoDir, Files: []config.File{{Source: "LICENSE"}}}
+
+	sources, err := PrepareSources(cfg, provider.NewGitHub("", "", github.StaticToken("")), cli.Backend{})
+	assert.NoError(t, err)
+	defer CleanupSources(sources)
+	assert.Equal(t, "owner1/template", sources[0].Repo)
+	assert.Equal(t, repoDir, sources[0].Dir)
+	assert.Len(t, sources[0].SHA, 40)
+}
+
+func Test_PrepareSources_Error(t *testing.T) {
+	cfg := &config.Config{Owner: "owner1", Repo: "template", Workspace: t.TempDir(), Files: []config.File{{Source: "LICENSE"}}}
+	_, err := PrepareSources(cfg, provider.NewGitHub("", "", github.StaticToken("")), cli.Backend{})
+	assert.Error(t, err)
+}
+
+func Test_PrepareSources_Remote(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	cfg := &config.Config{SourceRepo: "file://" + repoDir, Files: []config.File{{Source: "LICENSE"}}, SSHKey: "key"}
+
+	sources, err := PrepareSources(cfg, nil, cli.Backend{})
+	assert.NoError(t, err)
+	defer CleanupSources(sources)
+	assert.Equal(t, "file://"+repoDir, sources[0].Repo)
+	assert.NotEqual(t, repoDir, sources[0].Dir)
+	assert.Len(t, sources[0].SHA, 40)
+}
+
+func Test_PrepareSources_Cache(t *testing.T) {
+	repoDir := createTargetRepo(t)
+	cfg := &config.Config{CacheDir: t.TempDir(), Sources: []config.Source{
+		{Repo: "file://" + repoDir, Files: []config.File{{Source: "LICENSE"}}},
+		{Repo: "file://" + repoDir, Files: []config.File{{Source: "LICENSE", Destination: "LICENSE.copy"}}},
+	}}
+
+	sources, err := PrepareSources(cfg, nil, cli.Backend{})
+	assert.NoError(t, err)
+	assert.Equal(t, sources[0].Dir, sources[1].Dir)
+	assert.Equal(t, cfg.CacheDir, filepath.Dir(sources[0].Dir))
+
+	// The clone is kept for the next run
+	CleanupSources(sources)
+	_, err = os.Stat(filepath.Join(sources[0].Dir, ".git"))
+	assert.NoError(t, err)
+}
+
+func Test_sshCredentials(t *testing.T) {
+	auth, cleanup, err := sshCredentials(&config.Config{SSHKey: "key\n\n", SSHKnownHosts: "github.example.com ssh-ed25519 AAAA"})
+	assert.NoError(t, err)
+
+	key, err := ioutil.ReadFile(auth.SSHKeyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "key\n", string(key))
+	info, err := os.Stat(auth.SSHKeyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	knownHosts, err := ioutil.ReadFile(auth.KnownHostsFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "github.example.com ssh-ed25519 AAAA\n", string(knownHosts))
+
+	cleanup()
+	_, err = os.Stat(auth.SSHKeyFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_sshCredentials_None(t *testing.T) {
+	auth, cleanup, err := sshCredentials(testConfig())
+	assert.NoError(t, err)
+	cleanup()
+	assert.Equal(t, common.Credentials{}, auth)
+}
+
+func Test_FanOut_Errors(t *testing.T) {
+	cfg := testConfig()
+	cfg.Concurrency = 2
+	targets := []string{"localhost/not-a-repo1", "localhost/not-a-repo2", "localhost/not-a-repo3"}
+
+	results := FanOut(cfg, provider.NewGitHub(t.TempDir(), "", github.StaticToken("")), cli.Backend{}, targets, func(owner, repo, dir string) Result {
+		panic("should not be called")
+	})
+	assert.Len(t, results, 3)
+	for i, r := range results {
+		assert.Equal(t, targets[i], r.Repo)
+		assert.Equal(t, StatusFailed, r.Status)
+	}
+
+	err := Err(results)
+	assert.Contains(t, err.Error(), "3 of 3 target repositories failed")
+	assert.Contains(t, err.Error(), "localhost/not-a-repo2: ")
+}
+
+func Test_FanOut_GitLab_Subgroup(t *testing.T) {
+	serverDir := t.TempDir()
+	remoteDir := filepath.Join(serverDir, "group", "sub", "project1.git")
+	_, err := common.RunCommand("./", "git", "init", "--bare", "--initial-branch=main", remoteDir)
+	assert.NoError(t, err)
+
+	results := FanOut(testConfig(), provider.NewGitLab(serverDir, "", ""), cli.Backend{}, []string{"group/sub/project1"}, func(owner, repo, dir string) Result {
+		return Result{Repo: owner + "|" + repo, Status: StatusUpToDate}
+	})
+	assert.Len(t, results, 1)
+	assert.Equal(t, "group/sub|project1", results[0].Repo)
+	assert.Equal(t, StatusUpToDate, results[0].Status)
+}
+
+func Test_Err(t *testing.T) {
+	results := []Result{
+		{Repo: "owner1/repo1", Status: StatusUpdated},
+		{Repo: "owner1/repo2", Status: StatusFailed, Err: fmt.Errorf("test error")},
+	}
+	assert.EqualError(t, Err(results), "1 of 2 target repositories failed: owner1/repo2: test error")
+	assert.NoError(t, Err(results[:1]))
+}
+
+func Test_LogSummary(t *testing.T) {
+	results := []Result{
+		{Repo: "owner1/repo1", Status: StatusUpdated},
+		{Repo: "owner1/repo2", Status: StatusChanged, Changes: []string{" M LICENSE"}},
+		{Repo: "owner1/repo3", Status: StatusFailed, Err: fmt.Errorf("test error")},
+	}
+	assert.NotPanics(t, func() {
+		LogSummary(results)
+	})
+}